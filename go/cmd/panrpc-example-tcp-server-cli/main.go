@@ -20,7 +20,12 @@ type local struct {
 }
 
 func (s *local) Increment(ctx context.Context, delta int64) (int64, error) {
-	log.Println("Incrementing counter by", delta, "for remote with ID", rpc.GetRemoteID(ctx))
+	remoteAddr := "unknown address"
+	if peer, ok := rpc.PeerFromContext(ctx); ok {
+		remoteAddr = peer.RemoteAddr.String()
+	}
+
+	log.Println("Incrementing counter by", delta, "for remote with ID", rpc.GetRemoteID(ctx), "and address", remoteAddr)
 
 	return atomic.AddInt64(&s.counter, delta), nil
 }
@@ -112,7 +117,12 @@ func main() {
 			go func() {
 				defer conn.Close()
 
-				linkCtx, cancelLinkCtx := context.WithCancel(ctx)
+				linkCtx, cancelLinkCtx := context.WithCancel(rpc.WithPeer(ctx, &rpc.Peer{
+					Transport: "tcp",
+
+					LocalAddr:  conn.LocalAddr(),
+					RemoteAddr: conn.RemoteAddr(),
+				}))
 				defer cancelLinkCtx()
 
 				encoder := json.NewEncoder(conn)
@@ -159,7 +169,12 @@ func main() {
 		decoder := json.NewDecoder(conn)
 
 		if err := registry.LinkStream(
-			ctx,
+			rpc.WithPeer(ctx, &rpc.Peer{
+				Transport: "tcp",
+
+				LocalAddr:  conn.LocalAddr(),
+				RemoteAddr: conn.RemoteAddr(),
+			}),
 
 			func(v rpc.Message[json.RawMessage]) error {
 				return encoder.Encode(v)
@@ -195,7 +195,9 @@ func main() {
 	}()
 
 	if err := registry.LinkMessage(
-		ctx,
+		rpc.WithPeer(ctx, &rpc.Peer{
+			Transport: "valkey",
+		}),
 
 		func(b []byte) error {
 			if _, err := broker.XAdd(ctx, &redis.XAddArgs{
@@ -25,7 +25,12 @@ type local struct {
 }
 
 func (s *local) Increment(ctx context.Context, delta int64) (int64, error) {
-	log.Println("Incrementing counter by", delta, "for remote with ID", rpc.GetRemoteID(ctx))
+	peerID := "unknown peer"
+	if peer, ok := rpc.PeerFromContext(ctx); ok {
+		peerID = peer.ID
+	}
+
+	log.Println("Incrementing counter by", delta, "for remote with ID", rpc.GetRemoteID(ctx), "and peer ID", peerID)
 
 	return atomic.AddInt64(&s.counter, delta), nil
 }
@@ -206,7 +211,11 @@ func main() {
 			go func() {
 				defer remote.Conn.Close()
 
-				linkCtx, cancelLinkCtx := context.WithCancel(ctx)
+				linkCtx, cancelLinkCtx := context.WithCancel(rpc.WithPeer(ctx, &rpc.Peer{
+					Transport: "webrtc",
+
+					ID: remote.PeerID,
+				}))
 				defer cancelLinkCtx()
 
 				encoder := json.NewEncoder(remote.Conn)
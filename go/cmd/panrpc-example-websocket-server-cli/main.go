@@ -23,7 +23,12 @@ type local struct {
 }
 
 func (s *local) Increment(ctx context.Context, delta int64) (int64, error) {
-	log.Println("Incrementing counter by", delta, "for remote with ID", rpc.GetRemoteID(ctx))
+	userAgent := "unknown user agent"
+	if peer, ok := rpc.PeerFromContext(ctx); ok && peer.Header != nil {
+		userAgent = peer.Header.Get("User-Agent")
+	}
+
+	log.Println("Incrementing counter by", delta, "for remote with ID", rpc.GetRemoteID(ctx), "and user agent", userAgent)
 
 	return atomic.AddInt64(&s.counter, delta), nil
 }
@@ -140,7 +145,15 @@ func main() {
 
 				go func() {
 					if err := registry.LinkStream(
-						r.Context(),
+						rpc.WithPeer(r.Context(), &rpc.Peer{
+							Transport: "ws",
+
+							LocalAddr:  conn.LocalAddr(),
+							RemoteAddr: conn.RemoteAddr(),
+
+							TLS:    r.TLS,
+							Header: r.Header,
+						}),
 
 						func(v rpc.Message[json.RawMessage]) error {
 							return encoder.Encode(v)
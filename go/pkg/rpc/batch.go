@@ -0,0 +1,307 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pojntfx/panrpc/go/pkg/utils"
+)
+
+var (
+	ErrRemoteDoesNotExist = errors.New("remote does not exist")
+	ErrStreamInBatch      = errors.New("invalid return, calls in a batch can't return streams")
+	ErrInvalidBatch       = errors.New("invalid batch, the number of results doesn't match the number of calls")
+	ErrBatchCallRepeated  = errors.New("invalid call, calls in a batch can only be sent once")
+)
+
+// BatchCall is a call to a remote function that is sent together with other calls by `Registry.BatchCall`
+type BatchCall struct {
+	Function string // Function call path of the function to call, e.g. `Println` or `Nested.Println`
+	Args     []any  // Arguments to call the function with, excluding the context
+	Result   any    // Pointer to the value to unmarshal the return value of the function into; nil to discard it
+}
+
+// batchEntry is a call of a batch that passed the client interceptors and is sent to the remote
+type batchEntry struct {
+	ctx  context.Context
+	call *CallInfo
+
+	resultType reflect.Type // Type to unmarshal the return value of the function into; nil to discard it
+}
+
+// batchResult is the result of a call of a batch
+type batchResult struct {
+	value reflect.Value
+	err   error
+}
+
+// BatchCall sends `calls` to the remote with the ID `remoteID` as a single request, which calls the functions concurrently
+// and sends their results back as a single response. It returns the errors returned by the functions in the order of `calls`.
+// Each call passes the client interceptors, which can only call `next` once for it; the request is sent once all calls have
+// either called `next` or returned without it. Closures can be passed as arguments, but streams can't be passed or returned.
+func (r Registry[R, T]) BatchCall(
+	ctx context.Context, // Context for the calls
+
+	remoteID string, // ID of the remote to call the functions on
+	calls ...BatchCall, // Calls to send
+) ([]error, error) {
+	r.linksLock.Lock()
+	l, ok := r.links[remoteID]
+	r.linksLock.Unlock()
+
+	if !ok {
+		return nil, ErrRemoteDoesNotExist
+	}
+
+	var (
+		entries = make([]*batchEntry, len(calls))
+		results = make([]chan batchResult, len(calls))
+		errs    = make([]error, len(calls))
+
+		// Calls that haven't called `next` or returned yet
+		pending sync.WaitGroup
+
+		// Calls that haven't returned yet
+		running sync.WaitGroup
+	)
+	pending.Add(len(calls))
+	running.Add(len(calls))
+	for i, call := range calls {
+		i, call := i, call // Capture the call
+		results[i] = make(chan batchResult, 1)
+
+		var resultType reflect.Type
+		result := reflect.ValueOf(call.Result)
+		if call.Result != nil && (result.Kind() != reflect.Ptr || result.IsNil()) {
+			errs[i] = ErrInvalidReturn
+		} else if call.Result != nil {
+			resultType = result.Type().Elem()
+		}
+
+		var once sync.Once
+		invoker := func(ctx context.Context, call *CallInfo) ([]any, error) {
+			sent := false
+			once.Do(func() {
+				entries[i] = &batchEntry{ctx, call, resultType}
+				sent = true
+
+				pending.Done()
+			})
+
+			if !sent {
+				return nil, ErrBatchCallRepeated
+			}
+
+			res := <-results[i]
+			if res.err != nil {
+				return nil, res.err
+			}
+
+			if !res.value.IsValid() {
+				return []any{}, nil
+			}
+
+			return []any{res.value.Interface()}, nil
+		}
+
+		for j := len(l.hooks.ClientInterceptors) - 1; j >= 0; j-- {
+			interceptor := l.hooks.ClientInterceptors[j]
+			next := invoker // Capture the next invoker
+
+			invoker = func(ctx context.Context, call *CallInfo) ([]any, error) {
+				return interceptor(ctx, call, next)
+			}
+		}
+
+		go func() {
+			defer running.Done()
+
+			out, err := invoker(ctx, &CallInfo{
+				RemoteID: remoteID,
+				Function: call.Function,
+				Args:     append([]any{}, call.Args...),
+			})
+
+			// Calls that were short-circuited by an interceptor aren't sent
+			once.Do(pending.Done)
+
+			if errs[i] != nil {
+				return
+			}
+
+			if err != nil {
+				errs[i] = err
+
+				return
+			}
+
+			if resultType == nil {
+				return
+			}
+
+			if len(out) != 1 {
+				errs[i] = ErrInvalidReturn
+
+				return
+			}
+
+			v, err := convertInterceptedValue(out[0], resultType)
+			if err != nil {
+				errs[i] = ErrInvalidReturn
+
+				return
+			}
+
+			result.Elem().Set(v)
+		}()
+	}
+
+	pending.Wait()
+
+	batchResults, err := r.sendBatch(ctx, l, entries)
+	for i := range entries {
+		if entries[i] == nil {
+			continue
+		}
+
+		if err != nil {
+			results[i] <- batchResult{err: err}
+		} else {
+			results[i] <- batchResults[i]
+		}
+	}
+
+	running.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+// sendBatch sends the calls `entries` as a single request and returns their results in the same order; calls that are nil aren't sent
+func (r Registry[R, T]) sendBatch(
+	ctx context.Context,
+
+	l *link[T],
+	entries []*batchEntry,
+) ([]batchResult, error) {
+	// Closures passed as arguments are freed once the calls have returned
+	var freeClosures []func()
+	defer func() {
+		for _, freeClosure := range freeClosures {
+			freeClosure()
+		}
+	}()
+
+	cmd := utils.Request[T]{
+		Call:  uuid.NewString(),
+		Batch: []utils.Request[T]{},
+	}
+	for _, entry := range entries {
+		if entry == nil {
+			continue
+		}
+
+		batchCmd := utils.Request[T]{
+			Call:     uuid.NewString(),
+			Function: entry.call.Function,
+			Args:     []T{},
+			Deadline: remainingDeadline(entry.ctx),
+			Metadata: getOutgoingMetadata(entry.ctx),
+		}
+
+		for _, arg := range entry.call.Args {
+			v := reflect.ValueOf(arg)
+			if v.IsValid() {
+				var err error
+				v, err = encodeClosuresForCall(l, v, &freeClosures)
+				if err != nil {
+					return nil, err
+				}
+
+				arg = v.Interface()
+			}
+
+			b, err := l.marshal(arg)
+			if err != nil {
+				return nil, err
+			}
+			batchCmd.Args = append(batchCmd.Args, b)
+		}
+
+		cmd.Batch = append(cmd.Batch, batchCmd)
+	}
+
+	results := make([]batchResult, len(entries))
+	if len(cmd.Batch) == 0 {
+		return results, nil
+	}
+
+	b, err := cmd.Marshal(l.marshal)
+	if err != nil {
+		return nil, err
+	}
+
+	rr, err := l.responseResolver.Receive(cmd.Call, ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer l.responseResolver.Free(cmd.Call, context.Canceled)
+
+	if err := l.writeRequest(b); err != nil {
+		return nil, err
+	}
+
+	res, err := rr()
+	if err != nil {
+		// If the caller gave up on the calls, let the remote know so that it can cancel the contexts of the handlers
+		for _, batchCmd := range cmd.Batch {
+			l.cancelCall(ctx, batchCmd.Call, batchCmd.Deadline)
+		}
+
+		return nil, err
+	}
+
+	if len(res.batch) != len(cmd.Batch) {
+		return nil, ErrInvalidBatch
+	}
+
+	sent := 0
+	for i, entry := range entries {
+		if entry == nil {
+			continue
+		}
+
+		callRes := res.batch[sent]
+		sent++
+
+		handleResponseMetadata(entry.ctx, callRes.Metadata)
+
+		if strings.TrimSpace(callRes.Err) != "" {
+			results[i].err = decodeError(callRes.Err, callRes.Code, callRes.Details, l.unmarshal)
+
+			continue
+		}
+
+		if entry.resultType == nil {
+			continue
+		}
+
+		v, err := r.unmarshalReturnValue(l, callRes.Value, entry.resultType)
+		if err != nil {
+			results[i].err = err
+
+			continue
+		}
+
+		results[i].value = v
+	}
+
+	return results, nil
+}
@@ -0,0 +1,108 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+var anyType = reflect.TypeOf((*any)(nil)).Elem()
+
+// Remote is a handle to a connected remote that allows calling its functions by name, without having to declare them in a struct
+type Remote[R, T any] struct {
+	registry Registry[R, T]
+	link     *link[T]
+}
+
+// GetRemote returns the handle to the remote with the ID `remoteID`; this can also be called from within `ForRemotes`
+func (r Registry[R, T]) GetRemote(remoteID string) (*Remote[R, T], error) {
+	r.linksLock.Lock()
+	l, ok := r.links[remoteID]
+	r.linksLock.Unlock()
+
+	if !ok {
+		return nil, ErrRemoteDoesNotExist
+	}
+
+	return &Remote[R, T]{r, l}, nil
+}
+
+// ID returns the ID of the remote
+func (r *Remote[R, T]) ID() string {
+	return r.link.remoteID
+}
+
+// Call calls the function with the call path `name` on the remote, e.g. `Println` or `Nested.Println`. Closures and streams
+// can be passed as arguments just like with the functions of the remote struct. If `result` isn't nil, it needs to be a pointer
+// to a value that the return value of the function is unmarshalled into; if the function returns more than one value besides
+// the error, `result` needs to point to a slice. If the return value can't be unmarshalled into `result`, an error that
+// matches `ErrInvalidReturn` is returned.
+func (r *Remote[R, T]) Call(
+	ctx context.Context, // Context for the call
+
+	name string, // Function call path of the function to call
+	args []any, // Arguments to call the function with, excluding the context
+	result any, // Pointer to the value to unmarshal the return value of the function into; nil to discard it
+) error {
+	in := []reflect.Type{contextType}
+	argValues := []reflect.Value{reflect.ValueOf(ctx)}
+	for _, arg := range args {
+		v := reflect.ValueOf(arg)
+		if !v.IsValid() {
+			v = reflect.Zero(anyType)
+		}
+
+		in = append(in, v.Type())
+		argValues = append(argValues, v)
+	}
+
+	out := []reflect.Type{errorType}
+
+	var resultValue reflect.Value
+	if result != nil {
+		resultValue = reflect.ValueOf(result)
+		if resultValue.Kind() != reflect.Ptr || resultValue.IsNil() {
+			return ErrInvalidReturn
+		}
+
+		// Streams are received like with the functions of the remote struct, all other return values are unmarshalled
+		// below so that a return value that doesn't match `result` only fails the call, not the entire link
+		if getStreamKind(resultValue.Type().Elem()) != streamKindNone {
+			out = []reflect.Type{resultValue.Type().Elem(), errorType}
+		} else {
+			out = []reflect.Type{reflect.TypeOf((*T)(nil)).Elem(), errorType}
+		}
+	}
+
+	returnValues := r.registry.makeRPC(
+		r.link,
+
+		"",
+		name,
+		reflect.FuncOf(in, out, false),
+		callModeDefault,
+	).Call(argValues)
+
+	if err, ok := returnValues[len(returnValues)-1].Interface().(error); ok && err != nil {
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if getStreamKind(resultValue.Type().Elem()) != streamKindNone {
+		resultValue.Elem().Set(returnValues[0])
+
+		return nil
+	}
+
+	v, err := r.registry.unmarshalReturnValue(r.link, returnValues[0].Interface().(T), resultValue.Type().Elem())
+	if err != nil {
+		return errors.Join(ErrInvalidReturn, err)
+	}
+
+	resultValue.Elem().Set(v)
+
+	return nil
+}
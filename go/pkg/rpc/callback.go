@@ -0,0 +1,76 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+var (
+	callbackType = reflect.TypeOf((*callback)(nil)).Elem()
+)
+
+// callback is implemented by `*Callback[F]` for all `F`
+type callback interface {
+	closure() (closureID string, fn any)
+	functionType() reflect.Type
+	setRemote(fn reflect.Value, release func(ctx context.Context) error)
+}
+
+// Callback is a closure that stays callable by the remote it was passed to after the call it was passed to has returned,
+// until the remote releases it or the link to the remote is closed. Pass a callback created with `NewCallback` instead
+// of a function to an RPC that takes a `*Callback[F]` argument to keep it around, e.g. to subscribe to events. RPCs can
+// also return a `*Callback[F]` instead of a function to let the remote release it once it doesn't need it anymore.
+type Callback[F any] struct {
+	id string
+	fn F
+
+	releaseLock sync.Mutex
+	release     func(ctx context.Context) error
+}
+
+// NewCallback creates a new callback for `fn`, which needs to be a function that could also be passed to an RPC as a closure
+func NewCallback[F any](fn F) *Callback[F] {
+	return &Callback[F]{
+		id: uuid.NewString(),
+		fn: fn,
+	}
+}
+
+// Fn returns the function of the callback; for callbacks that were passed by a remote, it calls the closure on the remote
+func (c *Callback[F]) Fn() F {
+	return c.fn
+}
+
+// Release lets the remote that passed the callback know that it won't be called anymore.
+// It is a no-op for callbacks that were created locally or that were already released.
+func (c *Callback[F]) Release(ctx context.Context) error {
+	c.releaseLock.Lock()
+	release := c.release
+	c.release = nil
+	c.releaseLock.Unlock()
+
+	if release == nil {
+		return nil
+	}
+
+	return release(ctx)
+}
+
+func (c *Callback[F]) closure() (string, any) {
+	return c.id, c.fn
+}
+
+func (c *Callback[F]) functionType() reflect.Type {
+	return reflect.TypeOf((*F)(nil)).Elem()
+}
+
+func (c *Callback[F]) setRemote(fn reflect.Value, release func(ctx context.Context) error) {
+	c.fn = fn.Interface().(F)
+
+	c.releaseLock.Lock()
+	c.release = release
+	c.releaseLock.Unlock()
+}
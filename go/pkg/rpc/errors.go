@@ -0,0 +1,183 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/pojntfx/panrpc/go/pkg/utils"
+)
+
+var (
+	ErrNoErrorDetails = errors.New("error has no details that can be decoded")
+)
+
+const (
+	ErrorCodeCanceled         = "canceled"
+	ErrorCodeDeadlineExceeded = "deadline_exceeded"
+)
+
+type registeredError struct {
+	code string
+	err  error
+}
+
+var (
+	registeredErrors = []registeredError{
+		{ErrorCodeCanceled, context.Canceled},
+		{ErrorCodeDeadlineExceeded, context.DeadlineExceeded},
+
+		{"cannot_call_non_function", ErrCannotCallNonFunction},
+		{"invalid_args_count", ErrInvalidArgsCount},
+		{"invalid_arg", ErrInvalidArg},
+		{"closure_does_not_exist", ErrClosureDoesNotExist},
+		{"reference_does_not_exist", ErrReferenceDoesNotExist},
+		{"stream_in_batch", ErrStreamInBatch},
+		{"stream_to_queued_call", ErrStreamToQueuedCall},
+		{"link_rejected", ErrLinkRejected},
+		{"permission_denied", ErrPermissionDenied},
+		{"panicked_with_non_error_value", utils.ErrPanickedWithNonErrorValue},
+	}
+	registeredErrorsLock sync.RWMutex
+)
+
+// RegisterError registers a sentinel error so that it can be matched with `errors.Is` after being returned by a remote.
+// Both peers need to register the error with the same code; registering a code again replaces the previous error.
+func RegisterError(code string, err error) {
+	registeredErrorsLock.Lock()
+	defer registeredErrorsLock.Unlock()
+
+	for i, candidate := range registeredErrors {
+		if candidate.code == code {
+			registeredErrors[i].err = err
+
+			return
+		}
+	}
+
+	registeredErrors = append(registeredErrors, registeredError{code, err})
+}
+
+func findErrorCode(err error) string {
+	registeredErrorsLock.RLock()
+	defer registeredErrorsLock.RUnlock()
+
+	// Errors that were registered later take precedence over the built-in ones
+	for i := len(registeredErrors) - 1; i >= 0; i-- {
+		if errors.Is(err, registeredErrors[i].err) {
+			return registeredErrors[i].code
+		}
+	}
+
+	return ""
+}
+
+func findRegisteredError(code string) error {
+	if code == "" {
+		return nil
+	}
+
+	registeredErrorsLock.RLock()
+	defer registeredErrorsLock.RUnlock()
+
+	for _, candidate := range registeredErrors {
+		if candidate.code == code {
+			return candidate.err
+		}
+	}
+
+	return nil
+}
+
+// RemoteError is an error that was returned by a remote.
+// Return it from a local RPC to send a custom code and details to the caller.
+type RemoteError struct {
+	Code    string // Code of the error; sentinel errors registered with `RegisterError` are matched by it
+	Message string // Message of the error
+	Details any    // Optional details of the error; use `DecodeDetails` to decode them if the error was returned by a remote
+
+	sentinel         error
+	unmarshalDetails func(v any) error
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the sentinel error registered for the error's code, so that `errors.Is` works across the wire
+func (e *RemoteError) Unwrap() error {
+	if e.sentinel != nil {
+		return e.sentinel
+	}
+
+	return findRegisteredError(e.Code)
+}
+
+// DecodeDetails decodes the error's details into `v`
+func (e *RemoteError) DecodeDetails(v any) error {
+	if e.unmarshalDetails == nil {
+		return ErrNoErrorDetails
+	}
+
+	return e.unmarshalDetails(v)
+}
+
+func encodeError[T any](
+	err error,
+
+	marshal func(v any) (T, error),
+) (
+	message string,
+	code string,
+	details T,
+
+	_ error,
+) {
+	message = err.Error()
+
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) {
+		code = remoteErr.Code
+
+		if remoteErr.Details != nil {
+			var err error
+			details, err = marshal(remoteErr.Details)
+			if err != nil {
+				return "", "", details, err
+			}
+		}
+
+		if code != "" {
+			return message, code, details, nil
+		}
+	}
+
+	return message, findErrorCode(err), details, nil
+}
+
+func decodeError[T any](
+	message string,
+	code string,
+	details T,
+
+	unmarshal func(data T, v any) error,
+) error {
+	remoteErr := &RemoteError{
+		Code:    code,
+		Message: message,
+		Details: details,
+
+		sentinel: findRegisteredError(code),
+	}
+
+	if !reflect.ValueOf(&details).Elem().IsZero() {
+		remoteErr.unmarshalDetails = func(v any) error {
+			return unmarshal(details, v)
+		}
+	} else {
+		remoteErr.Details = nil
+	}
+
+	return remoteErr
+}
@@ -0,0 +1,138 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pojntfx/panrpc/go/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errRegisteredTest = errors.New("registered test error")
+)
+
+func init() {
+	RegisterError("registered_test", errRegisteredTest)
+}
+
+type errorDetailsTest struct {
+	Field string `json:"field"`
+}
+
+func encodeAndDecodeError(t *testing.T, err error) error {
+	marshal := func(v any) (json.RawMessage, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return json.RawMessage(b), nil
+	}
+	unmarshal := func(data json.RawMessage, v any) error {
+		return json.Unmarshal([]byte(data), v)
+	}
+
+	res := utils.Response[json.RawMessage]{}
+
+	var encodeErr error
+	res.Err, res.Code, res.Details, encodeErr = encodeError(err, marshal)
+	require.NoError(t, encodeErr)
+
+	b, merr := res.Marshal(marshal)
+	require.NoError(t, merr)
+
+	var decoded utils.Response[json.RawMessage]
+	require.NoError(t, decoded.Unmarshal(b, unmarshal))
+
+	return decodeError(decoded.Err, decoded.Code, decoded.Details, unmarshal)
+}
+
+func TestErrorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode string
+		expectedIs   error
+	}{
+		{
+			name:         "unregistered error",
+			err:          errTest,
+			expectedCode: "",
+			expectedIs:   nil,
+		},
+		{
+			name:         "context cancelled",
+			err:          context.Canceled,
+			expectedCode: ErrorCodeCanceled,
+			expectedIs:   context.Canceled,
+		},
+		{
+			name:         "context deadline exceeded",
+			err:          context.DeadlineExceeded,
+			expectedCode: ErrorCodeDeadlineExceeded,
+			expectedIs:   context.DeadlineExceeded,
+		},
+		{
+			name:         "wrapped registered error",
+			err:          fmt.Errorf("could not do the thing: %w", errRegisteredTest),
+			expectedCode: "registered_test",
+			expectedIs:   errRegisteredTest,
+		},
+		{
+			name:         "built-in error",
+			err:          ErrClosureDoesNotExist,
+			expectedCode: "closure_does_not_exist",
+			expectedIs:   ErrClosureDoesNotExist,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := encodeAndDecodeError(t, tt.err)
+
+			var remoteErr *RemoteError
+			require.ErrorAs(t, err, &remoteErr)
+			require.Equal(t, tt.expectedCode, remoteErr.Code)
+			require.Equal(t, tt.err.Error(), err.Error())
+
+			if tt.expectedIs != nil {
+				require.ErrorIs(t, err, tt.expectedIs)
+			} else {
+				require.Nil(t, errors.Unwrap(err))
+			}
+		})
+	}
+}
+
+func TestErrorRoundTripWithDetails(t *testing.T) {
+	err := encodeAndDecodeError(t, fmt.Errorf("could not validate: %w", &RemoteError{
+		Code:    "validation_failed",
+		Message: "field is invalid",
+		Details: errorDetailsTest{
+			Field: "name",
+		},
+	}))
+
+	var remoteErr *RemoteError
+	require.ErrorAs(t, err, &remoteErr)
+	require.Equal(t, "validation_failed", remoteErr.Code)
+	require.Equal(t, "could not validate: field is invalid", remoteErr.Message)
+
+	var details errorDetailsTest
+	require.NoError(t, remoteErr.DecodeDetails(&details))
+	require.Equal(t, "name", details.Field)
+}
+
+func TestErrorRoundTripWithoutDetails(t *testing.T) {
+	err := encodeAndDecodeError(t, errTest)
+
+	var remoteErr *RemoteError
+	require.ErrorAs(t, err, &remoteErr)
+	require.Nil(t, remoteErr.Details)
+
+	var details errorDetailsTest
+	require.ErrorIs(t, remoteErr.DecodeDetails(&details), ErrNoErrorDetails)
+}
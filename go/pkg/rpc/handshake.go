@@ -0,0 +1,211 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pojntfx/panrpc/go/pkg/utils"
+)
+
+var (
+	ErrLinkRejected = errors.New("link rejected")
+)
+
+// GetPrincipal returns the principal that `VerifyCredentials` returned for the remote that called a local RPC;
+// `ctx` needs to be the context passed to the RPC
+func GetPrincipal(ctx context.Context) any {
+	return ctx.Value(PrincipalContextKey)
+}
+
+type handshakeResult[T any] struct {
+	principal    any
+	firstMessage *T
+	err          error
+}
+
+// readAhead starts reading the first message with `read` in the background, since a transport might not be able to deliver
+// messages of one kind while a message of the other kind that was received before them hasn't been read yet. It returns
+// a function that returns the first message and reads the following ones with `read`.
+func readAhead[T any](read func() (T, error)) func() (T, error) {
+	first := make(chan handshakeResult[T], 1)
+	go func() {
+		b, err := read()
+		first <- handshakeResult[T]{nil, &b, err}
+	}()
+
+	return func() (T, error) {
+		if first == nil {
+			return read()
+		}
+
+		res := <-first
+		first = nil
+
+		return *res.firstMessage, res.err
+	}
+}
+
+// handshake sends `credentials` to the remote if they are set and waits for the remote to accept them, and verifies the
+// credentials that the remote sends with `verifyCredentials` if it is set. If the remote's first request is a call
+// instead of a handshake, the remote is verified without credentials. Requests need to be read with the returned
+// `readNextRequest` afterwards, since the first request might already have been read during the handshake.
+func (l *link[T]) handshake(
+	readRequest func() (T, error),
+	readResponse func() (T, error),
+
+	credentials Metadata,
+	verifyCredentials func(remoteID string, credentials Metadata) (principal any, err error),
+) (principal any, readNextRequest func() (T, error), err error) {
+	var (
+		sent     = make(chan handshakeResult[T], 1)
+		verified = make(chan handshakeResult[T], 1)
+	)
+
+	// Handshakes are only sent with credentials so that peers without them can still be linked to peers that don't support handshakes
+	if credentials == nil {
+		sent <- handshakeResult[T]{}
+	} else {
+		callID := uuid.NewString()
+
+		cmd := utils.Request[T]{
+			Call:      callID,
+			Handshake: true,
+			Metadata:  credentials,
+		}
+
+		b, err := cmd.Marshal(l.marshal)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := l.writeRequest(b); err != nil {
+			return nil, nil, err
+		}
+
+		// The response is read concurrently to the remote's handshake since transports might deliver them in any order
+		go func() {
+			b, err := readResponse()
+			if err != nil {
+				sent <- handshakeResult[T]{nil, nil, err}
+
+				return
+			}
+
+			var res utils.Response[T]
+			if err := res.Unmarshal(b, l.unmarshal); err != nil {
+				sent <- handshakeResult[T]{nil, nil, err}
+
+				return
+			}
+
+			if res.Call != callID {
+				sent <- handshakeResult[T]{nil, nil, ErrLinkRejected}
+
+				return
+			}
+
+			if strings.TrimSpace(res.Err) != "" {
+				err := decodeError(res.Err, res.Code, res.Details, l.unmarshal)
+				if !errors.Is(err, ErrLinkRejected) {
+					err = errors.Join(ErrLinkRejected, err)
+				}
+
+				sent <- handshakeResult[T]{nil, nil, err}
+
+				return
+			}
+
+			sent <- handshakeResult[T]{}
+		}()
+	}
+
+	if verifyCredentials == nil {
+		verified <- handshakeResult[T]{}
+	} else {
+		go func() {
+			b, err := readRequest()
+			if err != nil {
+				verified <- handshakeResult[T]{nil, nil, err}
+
+				return
+			}
+
+			var req utils.Request[T]
+			if err := req.Unmarshal(b, l.unmarshal); err != nil {
+				verified <- handshakeResult[T]{nil, nil, err}
+
+				return
+			}
+
+			var remoteCredentials Metadata
+			if req.Handshake {
+				remoteCredentials = req.Metadata
+			}
+
+			principal, err := verifyCredentials(l.remoteID, remoteCredentials)
+			if err != nil {
+				err = errors.Join(ErrLinkRejected, err)
+			}
+
+			if req.Handshake || (err != nil && !req.Notify) {
+				if err := l.writeCallResponse(req.Call, nil, err, nil); err != nil {
+					verified <- handshakeResult[T]{nil, nil, err}
+
+					return
+				}
+			}
+
+			if err != nil {
+				verified <- handshakeResult[T]{nil, nil, err}
+
+				return
+			}
+
+			if req.Handshake {
+				verified <- handshakeResult[T]{principal, nil, nil}
+			} else {
+				verified <- handshakeResult[T]{principal, &b, nil}
+			}
+		}()
+	}
+
+	readNextRequest = readRequest
+	if credentials != nil && verifyCredentials == nil {
+		// Requests are read ahead while waiting for the response to the handshake
+		readNextRequest = readAhead(readRequest)
+	}
+
+	// Fail as soon as either side of the handshake has failed
+	for i := 0; i < 2; i++ {
+		select {
+		case res := <-sent:
+			if res.err != nil {
+				return nil, nil, res.err
+			}
+		case res := <-verified:
+			if res.err != nil {
+				return nil, nil, res.err
+			}
+
+			principal = res.principal
+
+			if firstRequest := res.firstMessage; firstRequest != nil {
+				// The remote's first request was a call, which still needs to be handled
+				readNextRequest = func() (T, error) {
+					if firstRequest == nil {
+						return readRequest()
+					}
+
+					b := *firstRequest
+					firstRequest = nil
+
+					return b, nil
+				}
+			}
+		}
+	}
+
+	return principal, readNextRequest, nil
+}
@@ -0,0 +1,163 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+)
+
+// CallInfo describes a call that is intercepted by an `Interceptor`
+type CallInfo struct {
+	RemoteID string // ID of the remote that the call is sent to or received from
+	Function string // Function call path of the called function, e.g. `Println` or `Nested.Println`
+	Args     []any  // Arguments of the call, excluding the context; variadic arguments are passed individually
+}
+
+// Invoker makes the call described by `call` and returns its return values, excluding the error
+type Invoker func(ctx context.Context, call *CallInfo) (results []any, err error)
+
+// Interceptor is called for each intercepted call. It can inspect or change the call, its context, its results and its error,
+// and call `next` to continue with the next interceptor or the call itself; to short-circuit the call, it can return without calling `next`.
+type Interceptor func(ctx context.Context, call *CallInfo, next Invoker) (results []any, err error)
+
+// intercept implements a function of the same type as `fn` that calls `fn` through the chain of `interceptors`
+func intercept(
+	remoteID string,
+	name string,
+
+	fn reflect.Value,
+	interceptors []Interceptor,
+) reflect.Value {
+	// Functions without a context can't be called anyways, so there is nothing to intercept
+	if len(interceptors) == 0 || fn.Type().NumIn() == 0 {
+		return fn
+	}
+
+	functionType := fn.Type()
+	returnsErr := functionType.NumOut() > 0 && functionType.Out(functionType.NumOut()-1) == errorType
+
+	return reflect.MakeFunc(functionType, func(args []reflect.Value) []reflect.Value {
+		args = flattenVariadicArgs(functionType, args)
+
+		ctx, _ := args[0].Interface().(context.Context)
+
+		call := &CallInfo{
+			RemoteID: remoteID,
+			Function: name,
+			Args:     []any{},
+		}
+		for _, arg := range args[1:] {
+			call.Args = append(call.Args, arg.Interface())
+		}
+
+		invoker := func(ctx context.Context, call *CallInfo) ([]any, error) {
+			if functionType.IsVariadic() {
+				if len(call.Args) < functionType.NumIn()-2 {
+					return nil, ErrInvalidArgsCount
+				}
+			} else if len(call.Args) != functionType.NumIn()-1 {
+				return nil, ErrInvalidArgsCount
+			}
+
+			in := []reflect.Value{reflect.Zero(functionType.In(0))}
+			if ctx != nil {
+				in[0] = reflect.ValueOf(ctx)
+			}
+
+			for i, arg := range call.Args {
+				v, err := convertInterceptedValue(arg, getParamType(functionType, i+1))
+				if err != nil {
+					return nil, ErrInvalidArg
+				}
+
+				in = append(in, v)
+			}
+
+			out := fn.Call(in)
+
+			var err error
+			if returnsErr {
+				err, _ = out[len(out)-1].Interface().(error)
+				out = out[:len(out)-1]
+			}
+
+			results := []any{}
+			for _, v := range out {
+				results = append(results, v.Interface())
+			}
+
+			return results, err
+		}
+
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor := interceptors[i]
+			next := invoker // Capture the next invoker
+
+			invoker = func(ctx context.Context, call *CallInfo) ([]any, error) {
+				return interceptor(ctx, call, next)
+			}
+		}
+
+		results, err := invoker(ctx, call)
+
+		valueTypesCount := functionType.NumOut()
+		if returnsErr {
+			valueTypesCount--
+		}
+
+		out := []reflect.Value{}
+		for i := 0; i < valueTypesCount; i++ {
+			out = append(out, reflect.Zero(functionType.Out(i)))
+		}
+
+		// Interceptors that return an error don't have to return values
+		if len(results) == valueTypesCount {
+			for i, result := range results {
+				v, convertErr := convertInterceptedValue(result, functionType.Out(i))
+				if convertErr != nil {
+					for i := range out {
+						out[i] = reflect.Zero(functionType.Out(i))
+					}
+					err = ErrInvalidReturn
+
+					break
+				}
+
+				out[i] = v
+			}
+		} else if err == nil {
+			err = ErrInvalidReturn
+		}
+
+		if err != nil && !returnsErr {
+			panic(err)
+		}
+
+		if returnsErr {
+			errReturnValue := reflect.New(errorType)
+			if err != nil {
+				errReturnValue.Elem().Set(reflect.ValueOf(err))
+			}
+
+			out = append(out, errReturnValue.Elem())
+		}
+
+		return out
+	})
+}
+
+// convertInterceptedValue converts `v`, which an interceptor could have changed, to a value of type `t`
+func convertInterceptedValue(v any, t reflect.Type) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return reflect.Zero(t), nil
+	}
+
+	if rv.Type().AssignableTo(t) {
+		v := reflect.New(t).Elem()
+		v.Set(rv)
+
+		return v, nil
+	}
+
+	return convertValue(rv, t)
+}
@@ -7,7 +7,6 @@ import (
 	"sync"
 
 	"github.com/google/uuid"
-	"github.com/pojntfx/panrpc/go/pkg/utils"
 )
 
 var (
@@ -16,82 +15,53 @@ var (
 	ErrInvalidArgsCount = errors.New("invalid argument count")
 	ErrInvalidArg       = errors.New("invalid argument, either the type doesn't match or is too complex and can't be inspected")
 
-	ErrClosureDoesNotExist = errors.New("closure does not exist")
+	ErrClosureDoesNotExist   = errors.New("closure does not exist")
+	ErrReferenceDoesNotExist = errors.New("reference does not exist")
+	ErrStreamToQueuedCall    = errors.New("invalid argument, calls that are queued until a pipelined call has returned can't take streams")
 )
 
 type (
-	callClosureType = func(ctx context.Context, closureID string, args []interface{}) (interface{}, error)
+	releaseClosureType   = func(ctx context.Context, closureID string) error
+	releaseReferenceType = func(ctx context.Context, referenceID string) error
 )
 
-func createClosure(fn interface{}) (func(args ...interface{}) (interface{}, error), error) {
-	functionType := reflect.ValueOf(fn).Type()
+func createClosure(fn interface{}) (reflect.Value, error) {
+	function := reflect.ValueOf(fn)
+	functionType := function.Type()
 
 	if functionType.Kind() != reflect.Func {
-		return nil, ErrNotAFunction
+		return reflect.Value{}, ErrNotAFunction
 	}
 
-	if functionType.NumOut() <= 0 || functionType.NumOut() > 2 {
-		return nil, ErrInvalidReturn
+	if functionType.NumOut() <= 0 {
+		return reflect.Value{}, ErrInvalidReturn
 	}
 
 	if !functionType.Out(functionType.NumOut() - 1).Implements(errorType) {
-		return nil, ErrInvalidReturn
+		return reflect.Value{}, ErrInvalidReturn
 	}
 
-	return func(args ...interface{}) (interface{}, error) {
-		if len(args) != functionType.NumIn() {
-			return nil, ErrInvalidArgsCount
-		}
-
-		in := make([]reflect.Value, len(args))
-		for i, arg := range args {
-			convertedArgVal, err := convertValue(reflect.ValueOf(arg), functionType.In(i))
-			if err != nil {
-				return nil, ErrInvalidArg
-			}
-
-			in[i] = convertedArgVal
-		}
-
-		out, err := utils.Call(reflect.ValueOf(fn), in)
-		if err != nil {
-			return nil, err
-		}
-
-		if len(out) == 1 {
-			if out[0].IsValid() && !out[0].IsNil() {
-				return nil, out[0].Interface().(error)
-			}
-
-			return nil, nil
-		}
-
-		if out[1].IsValid() && !out[1].IsNil() {
-			return out[0].Interface(), out[1].Interface().(error)
-		}
-
-		return out[0].Interface(), nil
-	}, nil
+	return function, nil
 }
 
 type closureManager struct {
 	closuresLock sync.Mutex
-	closures     map[string]func(args ...interface{}) (interface{}, error)
+	closures     map[string]reflect.Value
+
+	// References of the remote to callbacks, indexed by closure ID
+	references map[string]int
 }
 
-func (m *closureManager) CallClosure(ctx context.Context, closureID string, args []interface{}) (interface{}, error) {
+func (m *closureManager) getClosure(closureID string) (reflect.Value, error) {
 	m.closuresLock.Lock()
+	defer m.closuresLock.Unlock()
+
 	closure, ok := m.closures[closureID]
 	if !ok {
-		m.closuresLock.Unlock()
-
-		return nil, ErrClosureDoesNotExist
+		return reflect.Value{}, ErrClosureDoesNotExist
 	}
-	m.closuresLock.Unlock()
-
-	var c context.Context = ctx
 
-	return closure(append([]interface{}{c}, args...)...)
+	return closure, nil
 }
 
 func registerClosure(m *closureManager, fn interface{}) (string, func(), error) {
@@ -112,3 +82,159 @@ func registerClosure(m *closureManager, fn interface{}) (string, func(), error)
 		m.closuresLock.Unlock()
 	}, nil
 }
+
+// registerCallback registers `fn` as the closure with the ID `closureID` if it isn't registered yet and adds a reference to it
+func registerCallback(m *closureManager, closureID string, fn interface{}) error {
+	cls, err := createClosure(fn)
+	if err != nil {
+		return err
+	}
+
+	m.closuresLock.Lock()
+	defer m.closuresLock.Unlock()
+
+	if m.references == nil {
+		m.references = map[string]int{}
+	}
+
+	m.closures[closureID] = cls
+	m.references[closureID]++
+
+	return nil
+}
+
+// ReleaseClosure removes a reference of the remote to a callback; the callback is removed once the remote doesn't reference it anymore
+func (m *closureManager) ReleaseClosure(ctx context.Context, closureID string) error {
+	m.closuresLock.Lock()
+	defer m.closuresLock.Unlock()
+
+	if m.references[closureID] <= 0 {
+		return ErrClosureDoesNotExist
+	}
+
+	m.references[closureID]--
+	if m.references[closureID] <= 0 {
+		delete(m.references, closureID)
+		delete(m.closures, closureID)
+	}
+
+	return nil
+}
+
+type referenceManager struct {
+	objectsLock sync.Mutex
+	objects     map[string]any
+
+	// References of the remote to objects, indexed by reference ID
+	references map[string]int
+
+	// Results of pipelined calls, indexed by call ID
+	promises map[string]*promise
+}
+
+// promise is the result of a pipelined call, which later calls can target before the call has returned
+type promise struct {
+	done chan struct{}
+
+	referenceID string
+	err         error
+}
+
+func (p *promise) wait(ctx context.Context) (string, error) {
+	select {
+	case <-p.done:
+		return p.referenceID, p.err
+
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (m *referenceManager) getObject(referenceID string) (any, error) {
+	m.objectsLock.Lock()
+	defer m.objectsLock.Unlock()
+
+	object, ok := m.objects[referenceID]
+	if !ok {
+		return nil, ErrReferenceDoesNotExist
+	}
+
+	return object, nil
+}
+
+// registerReference registers `object` as the object with the ID `referenceID` if it isn't registered yet and adds a reference to it
+func registerReference(m *referenceManager, referenceID string, object any) {
+	m.objectsLock.Lock()
+	defer m.objectsLock.Unlock()
+
+	m.objects[referenceID] = object
+	m.references[referenceID]++
+}
+
+// registerPromise registers the result of the pipelined call with the ID `callID`, which needs to be resolved with `resolvePromise`
+func registerPromise(m *referenceManager, callID string) {
+	m.objectsLock.Lock()
+	defer m.objectsLock.Unlock()
+
+	m.promises[callID] = &promise{
+		done: make(chan struct{}),
+	}
+}
+
+func (m *referenceManager) resolvePromise(callID string, referenceID string, err error) {
+	m.objectsLock.Lock()
+	defer m.objectsLock.Unlock()
+
+	p, ok := m.promises[callID]
+	if !ok {
+		return
+	}
+
+	p.referenceID = referenceID
+	p.err = err
+
+	close(p.done)
+}
+
+func (m *referenceManager) getPromise(callID string) *promise {
+	m.objectsLock.Lock()
+	defer m.objectsLock.Unlock()
+
+	return m.promises[callID]
+}
+
+// ReleaseReference removes a reference of the remote to an object; the object is removed once the remote doesn't reference it anymore.
+// References that were returned by pipelined calls are released with the ID of the call that returned them.
+func (m *referenceManager) ReleaseReference(ctx context.Context, referenceID string) error {
+	if p := m.getPromise(referenceID); p != nil {
+		resolvedReferenceID, err := p.wait(ctx)
+		if err != nil && ctx.Err() != nil {
+			return err
+		}
+
+		m.objectsLock.Lock()
+		delete(m.promises, referenceID)
+		m.objectsLock.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		referenceID = resolvedReferenceID
+	}
+
+	m.objectsLock.Lock()
+	defer m.objectsLock.Unlock()
+
+	if m.references[referenceID] <= 0 {
+		return ErrReferenceDoesNotExist
+	}
+
+	m.references[referenceID]--
+	if m.references[referenceID] <= 0 {
+		delete(m.references, referenceID)
+		delete(m.objects, referenceID)
+	}
+
+	return nil
+}
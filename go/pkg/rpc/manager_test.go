@@ -2,7 +2,10 @@ package rpc
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -13,10 +16,58 @@ var (
 	errTest = errors.New("test error")
 )
 
-func TestBasicClosureCreationAndCall(t *testing.T) {
-	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
+// closureCallerRemote calls the closures of a remote the same way that a remote calls the closures that were passed to it as arguments
+type closureCallerRemote[V any] struct {
+	CallClosure func(ctx context.Context, closureID string, args []interface{}) (V, error)
+}
+
+// linkClosureCaller links a registry to a remote that calls its closures and unmarshals their return values into `V`.
+// It returns the closure manager of the registry's link, so that closures can be registered without passing them as arguments.
+func linkClosureCaller[V any](t *testing.T, ctx context.Context) (*closureManager, closureCallerRemote[V]) {
+	var calleeConnected, callerConnected sync.WaitGroup
+	calleeConnected.Add(1)
+	callerConnected.Add(1)
+
+	calleeRegistry := NewRegistry[struct{}, json.RawMessage](struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			calleeConnected.Done()
+		},
+	})
+
+	callerRegistry := NewRegistry[closureCallerRemote[V], json.RawMessage](struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			callerConnected.Done()
+		},
+	})
+
+	// The links are closed once `ctx` is cancelled
+	_, _ = linkConns(t, ctx, calleeRegistry, callerRegistry, nil)
+
+	calleeConnected.Wait()
+	callerConnected.Wait()
+
+	var m *closureManager
+	calleeRegistry.linksLock.Lock()
+	for _, l := range calleeRegistry.links {
+		m = l.closures
 	}
+	calleeRegistry.linksLock.Unlock()
+
+	var remote closureCallerRemote[V]
+	require.NoError(t, callerRegistry.ForRemotes(func(remoteID string, r closureCallerRemote[V]) error {
+		remote = r
+
+		return nil
+	}))
+
+	return m, remote
+}
+
+func TestBasicClosureCreationAndCall(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, remote := linkClosureCaller[int](t, ctx)
 
 	fn := func(ctx context.Context, x int) (int, error) {
 		return x * 2, nil
@@ -26,15 +77,16 @@ func TestBasicClosureCreationAndCall(t *testing.T) {
 	require.NoError(t, err)
 	defer cleanup()
 
-	result, err := m.CallClosure(context.Background(), closureID, []interface{}{42})
+	result, err := remote.CallClosure(ctx, closureID, []interface{}{42})
 	require.NoError(t, err)
 	require.Equal(t, 84, result)
 }
 
 func TestClosureWithJustErrorReturn(t *testing.T) {
-	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, remote := linkClosureCaller[interface{}](t, ctx)
 
 	expectedErr := assert.AnError
 	fn := func(ctx context.Context) error {
@@ -45,15 +97,16 @@ func TestClosureWithJustErrorReturn(t *testing.T) {
 	require.NoError(t, err)
 	defer cleanup()
 
-	result, err := m.CallClosure(context.Background(), closureID, []interface{}{})
-	require.ErrorIs(t, err, expectedErr)
+	result, err := remote.CallClosure(ctx, closureID, []interface{}{})
+	require.ErrorContains(t, err, expectedErr.Error())
 	require.Nil(t, result)
 }
 
 func TestClosureWithJustErrorReturnNil(t *testing.T) {
-	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, remote := linkClosureCaller[interface{}](t, ctx)
 
 	fn := func(ctx context.Context) error {
 		return nil
@@ -63,15 +116,16 @@ func TestClosureWithJustErrorReturnNil(t *testing.T) {
 	require.NoError(t, err)
 	defer cleanup()
 
-	result, err := m.CallClosure(context.Background(), closureID, []interface{}{})
+	result, err := remote.CallClosure(ctx, closureID, []interface{}{})
 	require.NoError(t, err)
 	require.Nil(t, result)
 }
 
 func TestClosureWithValueReturnAndError(t *testing.T) {
-	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, remote := linkClosureCaller[int](t, ctx)
 
 	expectedErr := assert.AnError
 	fn := func(ctx context.Context) (int, error) {
@@ -82,14 +136,14 @@ func TestClosureWithValueReturnAndError(t *testing.T) {
 	require.NoError(t, err)
 	defer cleanup()
 
-	result, err := m.CallClosure(context.Background(), closureID, []interface{}{})
-	require.ErrorIs(t, err, expectedErr)
+	result, err := remote.CallClosure(ctx, closureID, []interface{}{})
+	require.ErrorContains(t, err, expectedErr.Error())
 	require.Equal(t, 5, result)
 }
 
 func TestClosureInvalidFunctionSignatureNoReturnValues(t *testing.T) {
 	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
+		closures: make(map[string]reflect.Value),
 	}
 
 	// Function with no return values
@@ -101,7 +155,7 @@ func TestClosureInvalidFunctionSignatureNoReturnValues(t *testing.T) {
 
 func TestClosureInvalidFunctionSignatureOneInvalidReturn(t *testing.T) {
 	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
+		closures: make(map[string]reflect.Value),
 	}
 
 	// Function with only a non-error return value
@@ -115,7 +169,7 @@ func TestClosureInvalidFunctionSignatureOneInvalidReturn(t *testing.T) {
 
 func TestClosureInvalidFunctionSignatureTwoInvalidReturns(t *testing.T) {
 	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
+		closures: make(map[string]reflect.Value),
 	}
 
 	// Function with two invalid return values since the second return value isn't an error
@@ -129,10 +183,10 @@ func TestClosureInvalidFunctionSignatureTwoInvalidReturns(t *testing.T) {
 
 func TestClosureInvalidFunctionSignatureMoreThanTwoReturns(t *testing.T) {
 	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
+		closures: make(map[string]reflect.Value),
 	}
 
-	// Function with three return values
+	// Function with three return values, none of which is an error
 	fn := func() (bool, bool, bool) {
 		return false, false, false
 	}
@@ -141,11 +195,32 @@ func TestClosureInvalidFunctionSignatureMoreThanTwoReturns(t *testing.T) {
 	require.ErrorIs(t, err, ErrInvalidReturn)
 }
 
-func TestClosureCleanupRemovesClosure(t *testing.T) {
-	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
+func TestClosureWithVariadicArgsAndMultipleReturnValues(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, remote := linkClosureCaller[[]int](t, ctx)
+
+	fn := func(ctx context.Context, values ...int) (int, int, error) {
+		return len(values), values[0], nil
 	}
 
+	closureID, cleanup, err := registerClosure(m, fn)
+	require.NoError(t, err)
+	defer cleanup()
+
+	// Variadic arguments are sent as individual arguments, and multiple return values as an array
+	result, err := remote.CallClosure(ctx, closureID, []interface{}{3, 2, 1})
+	require.NoError(t, err)
+	require.Equal(t, []int{3, 3}, result)
+}
+
+func TestClosureCleanupRemovesClosure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, remote := linkClosureCaller[interface{}](t, ctx)
+
 	fn := func(ctx context.Context) error {
 		return nil
 	}
@@ -157,14 +232,15 @@ func TestClosureCleanupRemovesClosure(t *testing.T) {
 	cleanup()
 
 	// Try to call the removed closure
-	_, err = m.CallClosure(context.Background(), closureID, []interface{}{})
+	_, err = remote.CallClosure(ctx, closureID, []interface{}{})
 	require.ErrorIs(t, err, ErrClosureDoesNotExist)
 }
 
 func TestClosureInvalidArgumentCount(t *testing.T) {
-	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, remote := linkClosureCaller[interface{}](t, ctx)
 
 	fn := func(ctx context.Context, x int) error {
 		return nil
@@ -175,14 +251,15 @@ func TestClosureInvalidArgumentCount(t *testing.T) {
 	defer cleanup()
 
 	// Call with wrong number of arguments
-	_, err = m.CallClosure(context.Background(), closureID, []interface{}{42, 43})
+	_, err = remote.CallClosure(ctx, closureID, []interface{}{42, 43})
 	require.ErrorIs(t, err, ErrInvalidArgsCount)
 }
 
 func TestClosureInvalidArgumentType(t *testing.T) {
-	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, remote := linkClosureCaller[interface{}](t, ctx)
 
 	fn := func(ctx context.Context, x int) error {
 		return nil
@@ -193,13 +270,13 @@ func TestClosureInvalidArgumentType(t *testing.T) {
 	defer cleanup()
 
 	// Call with wrong argument type (string instead of int)
-	_, err = m.CallClosure(context.Background(), closureID, []interface{}{"not an int"})
+	_, err = remote.CallClosure(ctx, closureID, []interface{}{"not an int"})
 	require.ErrorIs(t, err, ErrInvalidArg)
 }
 
 func TestClosureInvalidClosureKind(t *testing.T) {
 	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
+		closures: make(map[string]reflect.Value),
 	}
 
 	fn := 1
@@ -210,9 +287,10 @@ func TestClosureInvalidClosureKind(t *testing.T) {
 }
 
 func TestClosureWithPanic(t *testing.T) {
-	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, remote := linkClosureCaller[interface{}](t, ctx)
 
 	fn := func(ctx context.Context) error {
 		panic(errTest)
@@ -222,23 +300,25 @@ func TestClosureWithPanic(t *testing.T) {
 	require.NoError(t, err)
 	defer cleanup()
 
-	_, err = m.CallClosure(context.Background(), closureID, []interface{}{})
-	require.ErrorIs(t, err, errTest)
+	_, err = remote.CallClosure(ctx, closureID, []interface{}{})
+	require.ErrorContains(t, err, errTest.Error())
 }
 
 func TestClosureNonExistent(t *testing.T) {
-	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, remote := linkClosureCaller[interface{}](t, ctx)
 
-	_, err := m.CallClosure(context.Background(), "non-existent-id", []interface{}{})
+	_, err := remote.CallClosure(ctx, "non-existent-id", []interface{}{})
 	require.ErrorIs(t, err, ErrClosureDoesNotExist)
 }
 
 func TestClosureCallsConcurrent(t *testing.T) {
-	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, remote := linkClosureCaller[int](t, ctx)
 
 	fn := func(ctx context.Context, x int) (int, error) {
 		return x * 2, nil
@@ -254,7 +334,7 @@ func TestClosureCallsConcurrent(t *testing.T) {
 
 	for i := 0; i < numGoroutines; i++ {
 		go func(val int) {
-			result, err := m.CallClosure(context.Background(), closureID, []interface{}{val})
+			result, err := remote.CallClosure(ctx, closureID, []interface{}{val})
 			require.NoError(t, err)
 			require.Equal(t, val*2, result)
 			done <- struct{}{}
@@ -268,11 +348,15 @@ func TestClosureCallsConcurrent(t *testing.T) {
 }
 
 func TestClosureContextCancellation(t *testing.T) {
-	m := &closureManager{
-		closures: make(map[string]func(args ...interface{}) (interface{}, error)),
-	}
+	linkCtx, cancelLink := context.WithCancel(context.Background())
+	defer cancelLink()
+
+	m, remote := linkClosureCaller[interface{}](t, linkCtx)
 
+	called := make(chan struct{})
 	fn := func(ctx context.Context) error {
+		close(called)
+
 		<-ctx.Done()
 		return ctx.Err()
 	}
@@ -281,19 +365,73 @@ func TestClosureContextCancellation(t *testing.T) {
 	require.NoError(t, err)
 	defer cleanup()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(linkCtx)
 
 	// Start the closure in a goroutine
 	done := make(chan error)
 	go func() {
-		_, err := m.CallClosure(ctx, closureID, []interface{}{})
+		_, err := remote.CallClosure(ctx, closureID, []interface{}{})
 		done <- err
 	}()
 
-	// Cancel the context
+	// Cancel the context once the closure has been called
+	<-called
 	cancel()
 
 	// Check if the closure returned with context cancellation error
 	err = <-done
 	require.ErrorIs(t, err, context.Canceled)
 }
+
+func TestCallbackReferences(t *testing.T) {
+	m := &closureManager{
+		closures: make(map[string]reflect.Value),
+	}
+
+	fn := func(ctx context.Context, x int) (int, error) {
+		return x * 2, nil
+	}
+
+	require.NoError(t, registerCallback(m, "callback", fn))
+	require.NoError(t, registerCallback(m, "callback", fn))
+
+	require.NoError(t, m.ReleaseClosure(context.Background(), "callback"))
+
+	// The callback is still referenced once
+	closure, err := m.getClosure("callback")
+	require.NoError(t, err)
+	require.Equal(t, reflect.ValueOf(fn).Pointer(), closure.Pointer())
+
+	require.NoError(t, m.ReleaseClosure(context.Background(), "callback"))
+
+	_, err = m.getClosure("callback")
+	require.ErrorIs(t, err, ErrClosureDoesNotExist)
+
+	// Releasing more references than the remote holds fails
+	require.ErrorIs(t, m.ReleaseClosure(context.Background(), "callback"), ErrClosureDoesNotExist)
+}
+
+func TestObjectReferences(t *testing.T) {
+	m := &referenceManager{
+		objects:    map[string]any{},
+		references: map[string]int{},
+	}
+
+	registerReference(m, "reference", "object")
+	registerReference(m, "reference", "object")
+
+	require.NoError(t, m.ReleaseReference(context.Background(), "reference"))
+
+	// The object is still referenced once
+	object, err := m.getObject("reference")
+	require.NoError(t, err)
+	require.Equal(t, "object", object)
+
+	require.NoError(t, m.ReleaseReference(context.Background(), "reference"))
+
+	_, err = m.getObject("reference")
+	require.ErrorIs(t, err, ErrReferenceDoesNotExist)
+
+	// Releasing more references than the remote holds fails
+	require.ErrorIs(t, m.ReleaseReference(context.Background(), "reference"), ErrReferenceDoesNotExist)
+}
@@ -0,0 +1,88 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+)
+
+// Metadata are key-value pairs that are sent along with a call or its response, e.g. a trace ID, an auth token or a locale
+type Metadata map[string]string
+
+// responseMetadata collects the metadata that a local RPC sends with its response
+type responseMetadata struct {
+	lock     sync.Mutex
+	metadata Metadata
+}
+
+func (m *responseMetadata) get() Metadata {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.metadata
+}
+
+// WithOutgoingMetadata returns a copy of `ctx` that sends `md` with the calls that are made with it, in addition to the metadata that was already attached to `ctx`
+func WithOutgoingMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, OutgoingMetadataContextKey, mergeMetadata(getOutgoingMetadata(ctx), md))
+}
+
+// GetIncomingMetadata returns the metadata that was sent with the call to a local RPC; `ctx` needs to be the context passed to the RPC
+func GetIncomingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(IncomingMetadataContextKey).(Metadata)
+
+	return md
+}
+
+// SetResponseMetadata sends `md` with the response to the call to a local RPC, in addition to the metadata that was already set;
+// `ctx` needs to be the context passed to the RPC. Metadata that is set after the RPC has returned is not sent.
+func SetResponseMetadata(ctx context.Context, md Metadata) {
+	rm, ok := ctx.Value(responseMetadataContextKey).(*responseMetadata)
+	if !ok {
+		return
+	}
+
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	rm.metadata = mergeMetadata(rm.metadata, md)
+}
+
+// WithResponseMetadataHandler returns a copy of `ctx` that calls `handler` with the metadata of the responses to the calls that
+// are made with it. `handler` is only called if a response contains metadata, and it is called once for each call in a batch.
+func WithResponseMetadataHandler(ctx context.Context, handler func(md Metadata)) context.Context {
+	return context.WithValue(ctx, ResponseMetadataHandlerContextKey, handler)
+}
+
+func getOutgoingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(OutgoingMetadataContextKey).(Metadata)
+
+	return md
+}
+
+func handleResponseMetadata(ctx context.Context, md Metadata) {
+	if len(md) == 0 {
+		return
+	}
+
+	if handler, ok := ctx.Value(ResponseMetadataHandlerContextKey).(func(md Metadata)); ok && handler != nil {
+		handler(md)
+	}
+}
+
+// mergeMetadata returns a copy of `md` with the values of `other` added to it; `md` itself is not modified
+func mergeMetadata(md Metadata, other Metadata) Metadata {
+	if len(other) == 0 {
+		return md
+	}
+
+	merged := Metadata{}
+	for k, v := range md {
+		merged[k] = v
+	}
+
+	for k, v := range other {
+		merged[k] = v
+	}
+
+	return merged
+}
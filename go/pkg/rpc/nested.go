@@ -0,0 +1,288 @@
+package rpc
+
+import (
+	"reflect"
+	"sync"
+)
+
+var (
+	shadowTypes sync.Map // Cache of shadow types, indexed by the original type
+)
+
+type shadowTypeCacheEntry struct {
+	shadow        reflect.Type
+	containsFuncs bool
+}
+
+// getShadowType returns a type with the same structure as `t` in which all functions are replaced by closure IDs,
+// and whether `t` contains any functions. Functions in recursive types and interfaces are not replaced.
+func getShadowType(t reflect.Type) (shadow reflect.Type, containsFuncs bool) {
+	if entry, ok := shadowTypes.Load(t); ok {
+		return entry.(shadowTypeCacheEntry).shadow, entry.(shadowTypeCacheEntry).containsFuncs
+	}
+
+	shadow, containsFuncs = getShadowTypeRecursively(t, map[reflect.Type]struct{}{})
+
+	shadowTypes.Store(t, shadowTypeCacheEntry{shadow, containsFuncs})
+
+	return shadow, containsFuncs
+}
+
+func getShadowTypeRecursively(t reflect.Type, visiting map[reflect.Type]struct{}) (reflect.Type, bool) {
+	if _, ok := visiting[t]; ok {
+		return t, false
+	}
+
+	visiting[t] = struct{}{}
+	defer delete(visiting, t)
+
+	switch t.Kind() {
+	case reflect.Func:
+		return reflect.TypeOf(""), true
+
+	case reflect.Pointer:
+		elem, containsFuncs := getShadowTypeRecursively(t.Elem(), visiting)
+		if !containsFuncs {
+			return t, false
+		}
+
+		return reflect.PointerTo(elem), true
+
+	case reflect.Slice:
+		elem, containsFuncs := getShadowTypeRecursively(t.Elem(), visiting)
+		if !containsFuncs {
+			return t, false
+		}
+
+		return reflect.SliceOf(elem), true
+
+	case reflect.Array:
+		elem, containsFuncs := getShadowTypeRecursively(t.Elem(), visiting)
+		if !containsFuncs {
+			return t, false
+		}
+
+		return reflect.ArrayOf(t.Len(), elem), true
+
+	case reflect.Map:
+		elem, containsFuncs := getShadowTypeRecursively(t.Elem(), visiting)
+		if !containsFuncs {
+			return t, false
+		}
+
+		return reflect.MapOf(t.Key(), elem), true
+
+	case reflect.Struct:
+		var (
+			fields        = []reflect.StructField{}
+			containsFuncs = false
+		)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			// Unexported fields aren't marshalled anyways
+			if !field.IsExported() {
+				continue
+			}
+
+			fieldType, fieldContainsFuncs := getShadowTypeRecursively(field.Type, visiting)
+			if fieldContainsFuncs {
+				containsFuncs = true
+			}
+
+			fields = append(fields, reflect.StructField{
+				Name: field.Name,
+				Type: fieldType,
+				Tag:  field.Tag,
+			})
+		}
+
+		if !containsFuncs {
+			return t, false
+		}
+
+		return reflect.StructOf(fields), true
+	}
+
+	return t, false
+}
+
+// encodeClosures converts `v` into a value of its shadow type `shadow`, registering the functions it contains as closures with `register`
+func encodeClosures(v reflect.Value, shadow reflect.Type, register func(fn reflect.Value) (string, error)) (reflect.Value, error) {
+	if v.Type() == shadow {
+		return v, nil
+	}
+
+	rv := reflect.New(shadow).Elem()
+
+	switch v.Kind() {
+	case reflect.Func:
+		if v.IsNil() {
+			return rv, nil
+		}
+
+		closureID, err := register(v)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		rv.SetString(closureID)
+
+	case reflect.Pointer:
+		if v.IsNil() {
+			return rv, nil
+		}
+
+		elem, err := encodeClosures(v.Elem(), shadow.Elem(), register)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		rv.Set(reflect.New(shadow.Elem()))
+		rv.Elem().Set(elem)
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return rv, nil
+		}
+
+		rv.Set(reflect.MakeSlice(shadow, v.Len(), v.Len()))
+
+		fallthrough
+
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			elem, err := encodeClosures(v.Index(i), shadow.Elem(), register)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+
+			rv.Index(i).Set(elem)
+		}
+
+	case reflect.Map:
+		if v.IsNil() {
+			return rv, nil
+		}
+
+		rv.Set(reflect.MakeMapWithSize(shadow, v.Len()))
+
+		iter := v.MapRange()
+		for iter.Next() {
+			elem, err := encodeClosures(iter.Value(), shadow.Elem(), register)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+
+			rv.SetMapIndex(iter.Key(), elem)
+		}
+
+	case reflect.Struct:
+		for i := 0; i < shadow.NumField(); i++ {
+			field := shadow.Field(i)
+
+			elem, err := encodeClosures(v.FieldByName(field.Name), field.Type, register)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+
+			rv.Field(i).Set(elem)
+		}
+	}
+
+	return rv, nil
+}
+
+// decodeClosures converts `v`, which is a value of the shadow type of `t`, back into a value of type `t`,
+// implementing the functions it contains with `implement`
+func decodeClosures(v reflect.Value, t reflect.Type, implement func(closureID string, functionType reflect.Type) reflect.Value) reflect.Value {
+	if v.Type() == t {
+		return v
+	}
+
+	rv := reflect.New(t).Elem()
+
+	switch t.Kind() {
+	case reflect.Func:
+		if closureID := v.String(); closureID != "" {
+			rv.Set(implement(closureID, t))
+		}
+
+	case reflect.Pointer:
+		if v.IsNil() {
+			return rv
+		}
+
+		rv.Set(reflect.New(t.Elem()))
+		rv.Elem().Set(decodeClosures(v.Elem(), t.Elem(), implement))
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return rv
+		}
+
+		rv.Set(reflect.MakeSlice(t, v.Len(), v.Len()))
+
+		fallthrough
+
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			rv.Index(i).Set(decodeClosures(v.Index(i), t.Elem(), implement))
+		}
+
+	case reflect.Map:
+		if v.IsNil() {
+			return rv
+		}
+
+		rv.Set(reflect.MakeMapWithSize(t, v.Len()))
+
+		iter := v.MapRange()
+		for iter.Next() {
+			rv.SetMapIndex(iter.Key(), decodeClosures(iter.Value(), t.Elem(), implement))
+		}
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name := v.Type().Field(i).Name
+
+			field, _ := t.FieldByName(name)
+			rv.FieldByIndex(field.Index).Set(decodeClosures(v.Field(i), field.Type, implement))
+		}
+	}
+
+	return rv
+}
+
+// encodeClosuresForCall replaces the functions in `arg` with closures that are registered until the call it is passed to has returned
+func encodeClosuresForCall[T any](l *link[T], arg reflect.Value, freeClosures *[]func()) (reflect.Value, error) {
+	shadow, containsFuncs := getShadowType(arg.Type())
+	if !containsFuncs {
+		return arg, nil
+	}
+
+	return encodeClosures(arg, shadow, func(fn reflect.Value) (string, error) {
+		closureID, freeClosure, err := registerClosure(l.closures, fn.Interface())
+		if err != nil {
+			return "", err
+		}
+		*freeClosures = append(*freeClosures, freeClosure)
+
+		return closureID, nil
+	})
+}
+
+// encodeClosuresForReturn replaces the functions in `value` with closures that stay registered until the link is closed;
+// to let the remote release a closure earlier, an RPC returns a `*Callback[F]` instead
+func encodeClosuresForReturn[T any](l *link[T], value reflect.Value) (reflect.Value, error) {
+	shadow, containsFuncs := getShadowType(value.Type())
+	if !containsFuncs {
+		return value, nil
+	}
+
+	return encodeClosures(value, shadow, func(fn reflect.Value) (string, error) {
+		closureID, _, err := registerClosure(l.closures, fn.Interface())
+
+		return closureID, err
+	})
+}
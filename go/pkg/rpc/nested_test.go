@@ -0,0 +1,110 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type shadowTypeRecursive struct {
+	Children []*shadowTypeRecursive
+}
+
+func TestGetShadowType(t *testing.T) {
+	tests := []struct {
+		name          string
+		t             reflect.Type
+		containsFuncs bool
+		expected      reflect.Type
+	}{
+		{
+			name:          "scalar",
+			t:             reflect.TypeOf(0),
+			containsFuncs: false,
+			expected:      reflect.TypeOf(0),
+		},
+		{
+			name:          "function",
+			t:             reflect.TypeOf(func(ctx context.Context) error { return nil }),
+			containsFuncs: true,
+			expected:      reflect.TypeOf(""),
+		},
+		{
+			name:          "slice of functions",
+			t:             reflect.TypeOf([]func(ctx context.Context) error{}),
+			containsFuncs: true,
+			expected:      reflect.TypeOf([]string{}),
+		},
+		{
+			name:          "map of functions",
+			t:             reflect.TypeOf(map[string]func(ctx context.Context) error{}),
+			containsFuncs: true,
+			expected:      reflect.TypeOf(map[string]string{}),
+		},
+		{
+			name:          "struct without functions",
+			t:             reflect.TypeOf(closureEvent{}),
+			containsFuncs: false,
+			expected:      reflect.TypeOf(closureEvent{}),
+		},
+		{
+			name:          "recursive struct",
+			t:             reflect.TypeOf(shadowTypeRecursive{}),
+			containsFuncs: false,
+			expected:      reflect.TypeOf(shadowTypeRecursive{}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shadow, containsFuncs := getShadowType(tt.t)
+			require.Equal(t, tt.containsFuncs, containsFuncs)
+			require.Equal(t, tt.expected, shadow)
+		})
+	}
+}
+
+func TestEncodeAndDecodeClosures(t *testing.T) {
+	handlers := closureHandlers{
+		Name: "test",
+		OnDone: func(ctx context.Context) error {
+			return nil
+		},
+		Nested: &closureNestedHandlers{
+			OnDone: func(ctx context.Context) error {
+				return nil
+			},
+		},
+	}
+
+	shadow, containsFuncs := getShadowType(reflect.TypeOf(handlers))
+	require.True(t, containsFuncs)
+
+	registered := 0
+	encoded, err := encodeClosures(reflect.ValueOf(handlers), shadow, func(fn reflect.Value) (string, error) {
+		registered++
+
+		return "closure", nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, registered)
+	require.Equal(t, "test", encoded.FieldByName("Name").String())
+	require.Equal(t, "", encoded.FieldByName("OnData").String())
+	require.Equal(t, "closure", encoded.FieldByName("OnDone").String())
+
+	implemented := 0
+	decoded := decodeClosures(encoded, reflect.TypeOf(handlers), func(closureID string, functionType reflect.Type) reflect.Value {
+		implemented++
+
+		return reflect.MakeFunc(functionType, func(args []reflect.Value) []reflect.Value {
+			return []reflect.Value{reflect.Zero(errorType)}
+		})
+	}).Interface().(closureHandlers)
+	require.Equal(t, 2, implemented)
+	require.Equal(t, "test", decoded.Name)
+	require.Nil(t, decoded.OnData)
+	require.NotNil(t, decoded.OnDone)
+	require.NotNil(t, decoded.Nested.OnDone)
+}
@@ -0,0 +1,42 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+)
+
+// Peer describes the remote of a link as seen by the transport. Transports can attach it to the context passed to
+// `LinkMessage` or `LinkStream` with `WithPeer`, after which it is available to local RPCs with `PeerFromContext`.
+type Peer struct {
+	Transport string // Name of the transport, e.g. `tcp`, `unix`, `ws`, `webrtc` or `valkey`
+
+	LocalAddr  net.Addr // Local address of the connection; nil if the transport has none
+	RemoteAddr net.Addr // Remote address of the connection; nil if the transport has none
+
+	TLS    *tls.ConnectionState // State of the TLS connection, which includes the remote's certificates; nil if the connection isn't using TLS
+	Header http.Header          // HTTP headers of the request that established the connection, e.g. for WebSockets; nil if there is none
+
+	ID string // ID of the remote assigned by the transport, e.g. a weron peer ID; this is different from the remote ID of the link
+}
+
+// WithPeer returns a copy of `ctx` that `peer` is attached to
+func WithPeer(ctx context.Context, peer *Peer) context.Context {
+	return context.WithValue(ctx, PeerContextKey, peer)
+}
+
+// PeerFromContext returns the peer that was attached to `ctx` with `WithPeer` and whether there was one
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	peer, ok := ctx.Value(PeerContextKey).(*Peer)
+
+	return peer, ok && peer != nil
+}
+
+// RemoteIDFromContext returns the ID of the remote that called a local RPC and whether there was one; unlike `GetRemoteID`,
+// it doesn't panic if `ctx` isn't the context passed to a local RPC
+func RemoteIDFromContext(ctx context.Context) (string, bool) {
+	remoteID, ok := ctx.Value(RemoteIDContextKey).(string)
+
+	return remoteID, ok
+}
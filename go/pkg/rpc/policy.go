@@ -0,0 +1,263 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime/debug"
+	"strings"
+
+	"github.com/pojntfx/panrpc/go/pkg/utils"
+)
+
+var (
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Policy decides whether a remote may call a function; it returns an error to deny the call. The principal of the remote
+// can be retrieved from `ctx` with `GetPrincipal`. Policies are called twice for each call: First before its arguments
+// are decoded with `call.Args` being nil, so that remotes which may not call a function learn nothing about its signature,
+// and then again with the decoded arguments. Policies that depend on the arguments should allow calls without them.
+type Policy func(ctx context.Context, call *CallInfo) error
+
+// checkPolicies checks whether the remote may call the function of `req`, either before its arguments are decoded if `args` is nil
+// or with the decoded `args`. If the call is denied before its arguments are decoded, the streams the remote passed as arguments are ended.
+// Functions of closures and objects that were passed or returned to the remote are always allowed, since the remote can only call the ones it owns anyways.
+func (r Registry[R, T]) checkPolicies(
+	l *link[T],
+
+	callCtx context.Context,
+
+	req utils.Request[T],
+	args []reflect.Value,
+) error {
+	if (len(l.hooks.Policies) == 0 && len(l.hooks.PolicyTags) == 0) || req.Target != "" {
+		return nil
+	}
+
+	// Policies that are referenced by struct tags apply to all functions of the tagged fields
+	var (
+		root   any
+		path   string
+		prefix string // Function call path of the registered service that the function belongs to
+	)
+	function, err := r.findRegisteredFunction(req.Function)
+	if err == nil {
+		var service reflect.Value
+		service, path, _ = r.findRegisteredService(req.Function)
+
+		root, prefix = service.Interface(), strings.TrimSuffix(strings.TrimSuffix(req.Function, path), ".")
+	} else {
+		function, err = findMethodByFunctionCallPathRecursively(l.local, req.Function)
+		if err != nil {
+			// The function isn't a local RPC, so it is one of the link's closures or references
+			return nil
+		}
+
+		root, path = l.local, req.Function
+	}
+
+	// Functions that are promoted from embedded fields are also matched by the function call path through the embedded fields
+	fields, resolvedPath := resolveFunctionCallPath(root, path)
+	if prefix != "" && resolvedPath != "" {
+		resolvedPath = prefix + "." + resolvedPath
+	} else if prefix != "" {
+		resolvedPath = prefix
+	}
+
+	policies := []Policy{}
+	for pattern, policy := range l.hooks.Policies {
+		if matchesPolicyPattern(pattern, req.Function) || matchesPolicyPattern(pattern, resolvedPath) {
+			policies = append(policies, policy)
+		}
+	}
+
+	denied := false
+	for _, field := range fields {
+		name, ok := strings.CutPrefix(field.Tag.Get("panrpc"), "policy=")
+		if !ok {
+			continue
+		}
+
+		policy, ok := l.hooks.PolicyTags[name]
+		if !ok {
+			// Fail closed if a policy is missing
+			denied = true
+
+			break
+		}
+
+		policies = append(policies, policy)
+	}
+
+	if len(policies) == 0 && !denied {
+		return nil
+	}
+
+	call := &CallInfo{
+		RemoteID: l.remoteID,
+		Function: req.Function,
+	}
+
+	ctx := l.callContext(callCtx)
+	if args != nil {
+		var ok bool
+		if ctx, ok = args[0].Interface().(context.Context); !ok {
+			return ErrInvalidArgs
+		}
+
+		call.Args = []any{}
+		for _, arg := range args[1:] {
+			call.Args = append(call.Args, arg.Interface())
+		}
+	}
+
+	err = nil
+	if denied {
+		err = ErrPermissionDenied
+	} else {
+		for _, policy := range policies {
+			if err = runPolicy(ctx, policy, call); err != nil {
+				break
+			}
+		}
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, ErrPermissionDenied) {
+		err = errors.Join(ErrPermissionDenied, err)
+	}
+
+	if args == nil {
+		// The streams aren't registered yet, so the remote needs to be told that it can't use them
+		if _, endErr := l.endStreamArgs(function.Type(), req.Args, err); endErr != nil {
+			return errors.Join(err, endErr)
+		}
+	}
+
+	return err
+}
+
+// runPolicy calls `policy` and returns panics in it as errors, since policies can be called while requests are read
+func runPolicy(ctx context.Context, policy Policy, call *CallInfo) (err error) {
+	defer func() {
+		if recoveredErr := recover(); recoveredErr != nil {
+			err = &utils.PanicError{Value: recoveredErr, Stack: debug.Stack()}
+		}
+	}()
+
+	return policy(ctx, call)
+}
+
+// matchesPolicyPattern returns whether the function call path `pattern` of a policy matches `functionCallPath`;
+// patterns that end with `*` match all function call paths with that prefix
+func matchesPolicyPattern(pattern, functionCallPath string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(functionCallPath, prefix)
+	}
+
+	return pattern == functionCallPath
+}
+
+// resolveFunctionCallPath returns the struct fields on the function call path `functionCallPath` in `root`, including the
+// embedded fields that fields and methods are promoted from, and the function call path through all of these fields,
+// e.g. `Admin.Delete` for `Delete` if it is promoted from the embedded field `Admin`
+func resolveFunctionCallPath(root any, functionCallPath string) (fields []reflect.StructField, resolvedPath string) {
+	if functionCallPath == "" {
+		return fields, ""
+	}
+
+	var (
+		functionCallPathParts = strings.Split(functionCallPath, ".")
+		resolvedPathParts     = []string{}
+	)
+
+	t := reflect.TypeOf(root)
+	for i, name := range functionCallPathParts {
+		for t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		if t == nil || t.Kind() != reflect.Struct {
+			resolvedPathParts = append(resolvedPathParts, functionCallPathParts[i:]...)
+
+			break
+		}
+
+		if i == len(functionCallPathParts)-1 {
+			for _, field := range getMethodPromotionPath(t, name) {
+				fields = append(fields, field)
+				resolvedPathParts = append(resolvedPathParts, field.Name)
+			}
+
+			resolvedPathParts = append(resolvedPathParts, name)
+
+			break
+		}
+
+		structField, ok := t.FieldByName(name)
+		if !ok {
+			resolvedPathParts = append(resolvedPathParts, functionCallPathParts[i:]...)
+
+			break
+		}
+
+		// Fields can be promoted from embedded fields, too
+		for j := range structField.Index {
+			field := t.FieldByIndex(structField.Index[:j+1])
+
+			fields = append(fields, field)
+			resolvedPathParts = append(resolvedPathParts, field.Name)
+		}
+
+		t = structField.Type
+	}
+
+	return fields, strings.Join(resolvedPathParts, ".")
+}
+
+// getMethodPromotionPath returns the embedded fields of the struct type `t` that the method `name` is promoted from,
+// outermost first, or nothing if none of them has the method. If `t` declares a method with the same name as one of
+// its embedded fields itself, the fields are still returned, so that their policies apply to the method, too.
+func getMethodPromotionPath(t reflect.Type, name string) []reflect.StructField {
+	return getMethodPromotionPathRecursively(t, name, map[reflect.Type]struct{}{})
+}
+
+func getMethodPromotionPathRecursively(t reflect.Type, name string, visiting map[reflect.Type]struct{}) []reflect.StructField {
+	if _, ok := visiting[t]; ok {
+		return nil
+	}
+
+	visiting[t] = struct{}{}
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.Anonymous {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		switch fieldType.Kind() {
+		case reflect.Interface:
+			if _, ok := fieldType.MethodByName(name); ok {
+				return []reflect.StructField{field}
+			}
+
+		case reflect.Struct:
+			// The method set of the pointer includes the methods that are promoted from the field's own embedded fields
+			if _, ok := reflect.PointerTo(fieldType).MethodByName(name); ok {
+				return append([]reflect.StructField{field}, getMethodPromotionPathRecursively(fieldType, name, visiting)...)
+			}
+		}
+	}
+
+	return nil
+}
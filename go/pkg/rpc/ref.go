@@ -0,0 +1,76 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+var (
+	refType = reflect.TypeOf((*ref)(nil)).Elem()
+)
+
+// ref is implemented by `*Ref[T]` for all `T`
+type ref interface {
+	reference() (referenceID string, object any)
+	objectType() reflect.Type
+	setRemote(object reflect.Value, release func(ctx context.Context) error)
+}
+
+// Ref is a reference to an object that lets the remote call the object's methods, e.g. a room that was opened by an RPC.
+// Return a reference created with `NewRef` from an RPC to pass the object by reference instead of by value; the remote
+// then declares the return value as a `*Ref[T]`, where `T` is a struct of functions like the one passed to `NewRegistry` as `R`.
+// The object can be called by the remote until the remote releases the reference or the link to the remote is closed.
+type Ref[T any] struct {
+	id     string
+	object T
+
+	releaseLock sync.Mutex
+	release     func(ctx context.Context) error
+}
+
+// NewRef creates a new reference to `object`, which needs to be a value whose methods could also be exposed as local RPCs
+func NewRef[T any](object T) *Ref[T] {
+	return &Ref[T]{
+		id:     uuid.NewString(),
+		object: object,
+	}
+}
+
+// Object returns the object of the reference; for references that were returned by a remote, it calls the methods of the object on the remote
+func (r *Ref[T]) Object() T {
+	return r.object
+}
+
+// Release lets the remote that returned the reference know that its object won't be called anymore.
+// It is a no-op for references that were created locally or that were already released.
+func (r *Ref[T]) Release(ctx context.Context) error {
+	r.releaseLock.Lock()
+	release := r.release
+	r.release = nil
+	r.releaseLock.Unlock()
+
+	if release == nil {
+		return nil
+	}
+
+	return release(ctx)
+}
+
+func (r *Ref[T]) reference() (string, any) {
+	return r.id, r.object
+}
+
+func (r *Ref[T]) objectType() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func (r *Ref[T]) setRemote(object reflect.Value, release func(ctx context.Context) error) {
+	r.object = object.Interface().(T)
+
+	r.releaseLock.Lock()
+	r.release = release
+	r.releaseLock.Unlock()
+}
@@ -3,9 +3,12 @@ package rpc
 import (
 	"context"
 	"errors"
+	"math"
 	"reflect"
+	"runtime/debug"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pojntfx/panrpc/go/pkg/utils"
@@ -16,9 +19,10 @@ var (
 	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
 
 	ErrInvalidFunctionCallPath = errors.New("invalid or empty function call path")
-	ErrInvalidReturn           = errors.New("invalid return, can only return an error or a value and an error")
+	ErrInvalidReturn           = errors.New("invalid return, can only return an error or values followed by an error")
 	ErrReturnValueTooComplex   = errors.New("invalid return, either the type doesn't match or is too complex and can't be inspected")
 	ErrInvalidArgs             = errors.New("invalid arguments, first argument needs to be a context.Context")
+	ErrClosureInNotification   = errors.New("invalid arguments, notifications can't take closures since they are released before the remote can call them")
 
 	ErrCannotCallNonFunction = errors.New("can not call non function")
 )
@@ -27,10 +31,37 @@ type key int
 
 const (
 	RemoteIDContextKey key = iota
+	IncomingMetadataContextKey
+	OutgoingMetadataContextKey
+	ResponseMetadataHandlerContextKey
+	PrincipalContextKey
+	PeerContextKey
+	responseMetadataContextKey
 
 	DefaultResponseBufferLen = 1024
 )
 
+// callMode is the way in which a remote function is called, as set by its `panrpc` struct tag
+type callMode int
+
+const (
+	callModeDefault  callMode = iota
+	callModePipeline          // `panrpc:"pipeline"`
+	callModeNotify            // `panrpc:"notify"`
+)
+
+// getCallMode returns the mode in which the remote function in `field` is called
+func getCallMode(field reflect.StructField) callMode {
+	switch field.Tag.Get("panrpc") {
+	case "pipeline":
+		return callModePipeline
+	case "notify":
+		return callModeNotify
+	}
+
+	return callModeDefault
+}
+
 type Message[T any] struct {
 	Request  *T `json:"request"`
 	Response *T `json:"response"`
@@ -40,13 +71,127 @@ type callResponse[T any] struct {
 	value     T
 	err       error
 	cancelled bool
+
+	batch []utils.Response[T] // Responses to the calls in a batch, in the order of the calls
+
+	metadata Metadata
+}
+
+// link is the state of a single link to a remote
+type link[T any] struct {
+	// This is separate from the context that is the first argument to each RPC because we also
+	// want to be able to cancel all in-flight RPCs if the context passed to a `Link*()` function is cancelled
+	ctx context.Context
+
+	remoteID string
+
+	// Principal that was returned when verifying the remote's credentials; nil if the remote wasn't verified
+	principal any
+
+	// Hooks of the registry merged with the hooks of this link
+	hooks *RegistryHooks
+
+	// Local RPCs to expose to the remote, which are either shared between links or created for this link by a factory
+	local any
+
+	// Closures are scoped to the link that registered them so that other remotes can't call them
+	closures *closureManager
+
+	// Objects that were returned by reference are scoped to the link they were returned to
+	references *referenceManager
+
+	setErr           func(err error)
+	responseResolver *utils.Broadcaster[callResponse[T]]
+	streams          *streamManager[T]
+
+	writeRequest  func(b T) error
+	writeResponse func(b T) error
+
+	marshal   func(v any) (T, error)
+	unmarshal func(data T, v any) error
+}
+
+func (l *link[T]) encodeCallResponse(callID string, value any, callErr error, metadata Metadata) (*utils.Response[T], error) {
+	v, err := l.marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &utils.Response[T]{
+		Call:     callID,
+		Value:    v,
+		Err:      "",
+		Metadata: metadata,
+	}
+
+	if callErr != nil {
+		res.Err, res.Code, res.Details, err = encodeError(callErr, l.marshal)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func (l *link[T]) writeCallResponse(callID string, value any, callErr error, metadata Metadata) error {
+	res, err := l.encodeCallResponse(callID, value, callErr, metadata)
+	if err != nil {
+		return err
+	}
+
+	b, err := res.Marshal(l.marshal)
+	if err != nil {
+		return err
+	}
+
+	return l.writeResponse(b)
+}
+
+// remainingDeadline returns the time left until the deadline of `ctx` in milliseconds, rounded up; zero if `ctx` has no deadline.
+// The remaining time is sent with calls instead of the deadline itself so that clock skew between the peers doesn't matter.
+func remainingDeadline(ctx context.Context) int64 {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+
+	remaining := int64(math.Ceil(float64(time.Until(deadline)) / float64(time.Millisecond)))
+	if remaining < 1 {
+		return 1
+	}
+
+	return remaining
+}
+
+// writeCancel lets the remote know that the caller isn't interested in the call with the ID `callID` anymore, so that it can cancel the context of its handler
+func (l *link[T]) writeCancel(callID string) error {
+	cmd := utils.Request[T]{
+		Call:   callID,
+		Cancel: true,
+	}
+
+	b, err := cmd.Marshal(l.marshal)
+	if err != nil {
+		return err
+	}
+
+	return l.writeRequest(b)
 }
 
-type wrappedChild struct {
-	wrappee any
-	wrapper *closureManager
+// cancelCall cancels the call with the ID `callID` on the remote if the caller gave up on it because `ctx` was cancelled. If the deadline
+// `deadline` that was sent with the call has been exceeded, the handler's context has the same deadline, so it isn't cancelled since the
+// cancellation could arrive before its deadline is exceeded, in which case it would see `context.Canceled` instead.
+func (l *link[T]) cancelCall(ctx context.Context, callID string, deadline int64) {
+	if ctx.Err() == nil || (deadline > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded)) {
+		return
+	}
+
+	// If this fails, the link is closed and the call has been cancelled anyways
+	_ = l.writeCancel(callID)
 }
 
+// GetRemoteID returns the ID of the remote that called a local RPC; it panics if `ctx` isn't the context passed to a local RPC, see `RemoteIDFromContext`
 func GetRemoteID(ctx context.Context) string {
 	return ctx.Value(RemoteIDContextKey).(string)
 }
@@ -54,24 +199,130 @@ func GetRemoteID(ctx context.Context) string {
 type RegistryHooks struct {
 	OnClientConnect    func(remoteID string)
 	OnClientDisconnect func(remoteID string)
+
+	OnPanic func(remoteID string, function string, value any, stack []byte) // Called if a local RPC panics
+
+	Credentials       Metadata                                                               // Credentials to send to the remote when a link is established; without them, the remote verifies this peer once it calls its first RPC
+	VerifyCredentials func(remoteID string, credentials Metadata) (principal any, err error) // Called with the remote's credentials when a link is established; the link is rejected if it returns an error
+
+	Policies   map[string]Policy // Policies for calls to local RPCs, keyed by function call path, e.g. `Admin.Delete`; paths that end with `*` match all functions with that prefix, e.g. `Admin.*`
+	PolicyTags map[string]Policy // Policies for calls to local RPCs that fields of the local RPCs can refer to with a `panrpc:"policy=<name>"` struct tag
+
+	ServerInterceptors []Interceptor // Called in order for each call to a local RPC
+	ClientInterceptors []Interceptor // Called in order for each call to a remote RPC, including each call sent with `BatchCall`
 }
 
+// LinkHooks are the hooks for a single link, which apply in addition to the registry's hooks
 type LinkHooks RegistryHooks
 
+// mergeHooks returns the hooks for a link with the hooks `linkHooks` to a registry with the hooks `registryHooks`.
+// The callbacks and interceptors of both are called, the registry's first, calls need to be allowed by the policies
+// of both, and the link's credentials and credential verifier take precedence over the registry's.
+func mergeHooks(registryHooks *RegistryHooks, linkHooks *LinkHooks) *RegistryHooks {
+	hooks := *registryHooks
+
+	hooks.OnClientConnect = mergeCallbacks(registryHooks.OnClientConnect, linkHooks.OnClientConnect)
+	hooks.OnClientDisconnect = mergeCallbacks(registryHooks.OnClientDisconnect, linkHooks.OnClientDisconnect)
+
+	if linkHooks.OnPanic != nil {
+		if registryOnPanic := registryHooks.OnPanic; registryOnPanic != nil {
+			hooks.OnPanic = func(remoteID, function string, value any, stack []byte) {
+				registryOnPanic(remoteID, function, value, stack)
+				linkHooks.OnPanic(remoteID, function, value, stack)
+			}
+		} else {
+			hooks.OnPanic = linkHooks.OnPanic
+		}
+	}
+
+	if linkHooks.Credentials != nil {
+		hooks.Credentials = linkHooks.Credentials
+	}
+
+	if linkHooks.VerifyCredentials != nil {
+		hooks.VerifyCredentials = linkHooks.VerifyCredentials
+	}
+
+	hooks.Policies = mergePolicies(registryHooks.Policies, linkHooks.Policies)
+	hooks.PolicyTags = mergePolicies(registryHooks.PolicyTags, linkHooks.PolicyTags)
+
+	hooks.ServerInterceptors = append(append([]Interceptor{}, registryHooks.ServerInterceptors...), linkHooks.ServerInterceptors...)
+	hooks.ClientInterceptors = append(append([]Interceptor{}, registryHooks.ClientInterceptors...), linkHooks.ClientInterceptors...)
+
+	return &hooks
+}
+
+// mergeCallbacks returns a callback that calls `first` and then `second`, either of which can be nil
+func mergeCallbacks(first, second func(remoteID string)) func(remoteID string) {
+	if first == nil {
+		return second
+	}
+
+	if second == nil {
+		return first
+	}
+
+	return func(remoteID string) {
+		first(remoteID)
+		second(remoteID)
+	}
+}
+
+// mergePolicies returns the policies of both `first` and `second`; if both have a policy with the same key, calls need to be allowed by both
+func mergePolicies(first, second map[string]Policy) map[string]Policy {
+	if len(second) == 0 {
+		return first
+	}
+
+	policies := map[string]Policy{}
+	for key, policy := range first {
+		policies[key] = policy
+	}
+
+	for key, policy := range second {
+		policy := policy // Capture the policy
+
+		firstPolicy, ok := policies[key]
+		if !ok {
+			policies[key] = policy
+
+			continue
+		}
+
+		policies[key] = func(ctx context.Context, call *CallInfo) error {
+			if err := firstPolicy(ctx, call); err != nil {
+				return err
+			}
+
+			return policy(ctx, call)
+		}
+	}
+
+	return policies
+}
+
 // Registry exposes local RPCs and implements remote RPCs
 type Registry[R, T any] struct {
-	local  wrappedChild
+	local  any
 	remote R
 
+	// Services that were registered at runtime with `Register`, indexed by function call path
+	services     map[string]reflect.Value
+	servicesLock *sync.RWMutex
+
 	remotes     map[string]R
 	remotesLock *sync.Mutex
 
+	// Links to the remotes, indexed by remote ID; this has a separate lock so that it can be accessed from within `ForRemotes`
+	links     map[string]*link[T]
+	linksLock *sync.Mutex
+
 	hooks *RegistryHooks
 }
 
 // NewRegistry creates a new registry
 func NewRegistry[R, T any]( // Type of remote RPCs to implement, type of nested values
-	local any, // Struct of local RPCs to expose
+	local any, // Struct of local RPCs to expose, or a function that creates it for each link, which needs to be exactly a `func(remoteID string) any`
 
 	hooks *RegistryHooks, // Global hooks
 ) *Registry[R, T] {
@@ -79,31 +330,18 @@ func NewRegistry[R, T any]( // Type of remote RPCs to implement, type of nested
 		hooks = &RegistryHooks{}
 	}
 
-	return &Registry[R, T]{wrappedChild{
-		local,
-		&closureManager{
-			closuresLock: sync.Mutex{},
-			closures:     map[string]func(args ...interface{}) (interface{}, error){},
-		},
-	}, *new(R), map[string]R{}, &sync.Mutex{}, hooks}
+	return &Registry[R, T]{local, *new(R), map[string]reflect.Value{}, &sync.RWMutex{}, map[string]R{}, &sync.Mutex{}, map[string]*link[T]{}, &sync.Mutex{}, hooks}
 }
 
 func (r Registry[R, T]) makeRPC(
-	// This is separate from the context that is the first argument to each RPC because we also
-	// want to be able to cancel all in-flight RPCs if the context passed to a `Link*()` function is cancelled
-	linkCtx context.Context,
+	l *link[T],
 
+	target string, // ID of the reference to the object to call the function on; empty for the remote's RPCs
 	name string,
 	functionType reflect.Type,
-	setErr func(err error),
-	responseResolver *utils.Broadcaster[callResponse[T]],
-
-	writeRequest func(b T) error,
-
-	marshal func(v any) (T, error),
-	unmarshal func(data T, v any) error,
+	mode callMode, // Whether to wait for the call to return, return a reference to its result right away or not wait for it at all
 ) reflect.Value {
-	return reflect.MakeFunc(functionType, func(args []reflect.Value) (results []reflect.Value) {
+	return intercept(l.remoteID, name, reflect.MakeFunc(functionType, func(args []reflect.Value) (results []reflect.Value) {
 		defer func() {
 			var err error
 			if e := recover(); e != nil {
@@ -113,20 +351,12 @@ func (r Registry[R, T]) makeRPC(
 					err = utils.ErrPanickedWithNonErrorValue
 				}
 
-				setErr(err)
+				l.setErr(err)
 			}
 
 			// If we tried to return with an invalid results count, set them so that the call doesn't panic
 			if len(results) != functionType.NumOut() {
-				errReturnValue := reflect.ValueOf(err)
-
-				if functionType.NumOut() == 1 {
-					results = []reflect.Value{errReturnValue}
-				} else if functionType.NumOut() == 2 {
-					valueReturnValue := reflect.Zero(functionType.Out(0))
-
-					results = []reflect.Value{valueReturnValue, errReturnValue}
-				}
+				results = makeErrorResults(functionType, err)
 			}
 		}()
 
@@ -136,10 +366,28 @@ func (r Registry[R, T]) makeRPC(
 			Call:     callID,
 			Function: name,
 			Args:     []T{},
+			Target:   target,
+			Pipeline: mode == callModePipeline,
+			Notify:   mode == callModeNotify,
 		}
 
-		var ctx context.Context
-		for i, arg := range args {
+		var (
+			ctx context.Context
+
+			// Streams passed as arguments are started once the request has been sent, and ended if the call fails,
+			// since the remote might not have registered them, e.g. if the function doesn't exist or the arguments are invalid
+			startStreams []func()
+			endStreams   []func(err error)
+
+			// Closures passed as arguments are freed once the call has returned
+			freeClosures []func()
+		)
+		defer func() {
+			for _, freeClosure := range freeClosures {
+				freeClosure()
+			}
+		}()
+		for i, arg := range flattenVariadicArgs(functionType, args) {
 			if i == 0 {
 				v, ok := arg.Interface().(context.Context)
 				if !ok {
@@ -151,20 +399,62 @@ func (r Registry[R, T]) makeRPC(
 				continue
 			}
 
-			if arg.Kind() == reflect.Func {
-				closureID, freeClosure, err := registerClosure(r.local.wrapper, arg.Interface())
+			arg := arg // Capture the argument
+
+			if getStreamKind(arg.Type()) != streamKindNone {
+				streamID := uuid.NewString()
+				outgoing := l.streams.registerOutgoing(streamID)
+
+				startStreams = append(startStreams, func() {
+					if err := l.sendStream(ctx, streamID, arg, outgoing, l.streamWriter(true), nil); err != nil {
+						l.setErr(err)
+					}
+				})
+				endStreams = append(endStreams, func(err error) {
+					outgoing.cancel()
+				})
+
+				b, err := l.marshal(streamID)
+				if err != nil {
+					panic(err)
+				}
+				cmd.Args = append(cmd.Args, b)
+			} else if isSendChan(arg.Type()) {
+				streamID := uuid.NewString()
+				incoming := l.streams.registerIncoming(streamID, l.streamWriter(true))
+
+				startStreams = append(startStreams, func() {
+					l.receiveStreamInto(ctx, incoming, arg, l.releaseStream(streamID, true))
+				})
+				endStreams = append(endStreams, func(err error) {
+					// If the remote has already closed the stream, its values are still received
+					incoming.close(err)
+				})
+
+				b, err := l.marshal(streamID)
 				if err != nil {
 					panic(err)
 				}
-				defer freeClosure()
+				cmd.Args = append(cmd.Args, b)
+			} else if cb, ok := arg.Interface().(callback); ok && !arg.IsNil() {
+				// Callbacks stay registered after the call has returned, until the remote releases them or the link is closed
+				closureID, fn := cb.closure()
+				if err := registerCallback(l.closures, closureID, fn); err != nil {
+					panic(err)
+				}
 
-				b, err := marshal(closureID)
+				b, err := l.marshal(closureID)
 				if err != nil {
 					panic(err)
 				}
 				cmd.Args = append(cmd.Args, b)
 			} else {
-				b, err := marshal(arg.Interface())
+				v, err := encodeClosuresForCall(l, arg, &freeClosures)
+				if err != nil {
+					panic(err)
+				}
+
+				b, err := l.marshal(v.Interface())
 				if err != nil {
 					panic(err)
 				}
@@ -172,44 +462,103 @@ func (r Registry[R, T]) makeRPC(
 			}
 		}
 
-		b, err := cmd.Marshal(marshal)
+		cmd.Metadata = getOutgoingMetadata(ctx)
+
+		cmd.Deadline = remainingDeadline(ctx)
+
+		b, err := cmd.Marshal(l.marshal)
 		if err != nil {
 			panic(err)
 		}
 
+		// Register the stream before sending the request so that no values of it get lost
+		var stream *incomingStream[T]
+		if functionType.NumOut() == 2 && getStreamKind(functionType.Out(0)) != streamKindNone {
+			stream = l.streams.registerIncoming(callID, l.streamWriter(true))
+		}
+
 		res := make(chan callResponse[T])
-		go func() {
-			defer responseResolver.Free(callID, context.Canceled)
+		if mode != callModeNotify {
+			go func() {
+				defer l.responseResolver.Free(callID, context.Canceled)
 
-			rr, err := responseResolver.Receive(callID, ctx)
-			if err != nil {
-				res <- callResponse[T]{*new(T), err, true}
+				rr, err := l.responseResolver.Receive(callID, ctx)
+				if err != nil {
+					res <- callResponse[T]{*new(T), err, true, nil, nil}
 
-				return
-			}
+					return
+				}
 
-			r, err := rr()
-			if err != nil {
-				r = &callResponse[T]{*new(T), err, true}
-			}
+				r, err := rr()
+				if err != nil {
+					r = &callResponse[T]{*new(T), err, true, nil, nil}
+				}
 
-			res <- *r
-		}()
+				res <- *r
+			}()
+		}
 
-		if err := writeRequest(b); err != nil {
+		if err := l.writeRequest(b); err != nil {
 			panic(err)
 		}
 
+		for _, startStream := range startStreams {
+			go startStream()
+		}
+
+		switch mode {
+		case callModeNotify:
+			// The remote doesn't respond to notifications, so they return once the request has been sent
+			return []reflect.Value{reflect.Zero(functionType.Out(0))}
+
+		case callModePipeline:
+			// Calls to the reference target the result of this call, so they are queued by the remote until this call has returned
+			freePipelinedClosures := freeClosures
+			freeClosures = nil
+
+			go func() {
+				if rawReturnValue := <-res; rawReturnValue.err != nil {
+					for _, endStream := range endStreams {
+						endStream(rawReturnValue.err)
+					}
+				}
+
+				for _, freeClosure := range freePipelinedClosures {
+					freeClosure()
+				}
+			}()
+
+			v, err := r.makeRef(l, callID, functionType.Out(0))
+			if err != nil {
+				panic(err)
+			}
+
+			return []reflect.Value{v, reflect.Zero(functionType.Out(1))}
+		}
+
 		returnValues := []reflect.Value{}
 		select {
 		case rawReturnValue := <-res:
+			// If the caller gave up on the call, let the remote know so that it can cancel the context of the handler
+			if rawReturnValue.cancelled {
+				l.cancelCall(ctx, callID, cmd.Deadline)
+			}
+
+			if rawReturnValue.err != nil {
+				for _, endStream := range endStreams {
+					endStream(rawReturnValue.err)
+				}
+			}
+
+			handleResponseMetadata(ctx, rawReturnValue.metadata)
+
 			if functionType.NumOut() == 1 {
 				returnValue := reflect.New(functionType.Out(0))
 
 				if rawReturnValue.err != nil {
 					returnValue.Elem().Set(reflect.ValueOf(rawReturnValue.err))
 				} else if !functionType.Out(0).Implements(errorType) {
-					if err := unmarshal(rawReturnValue.value, returnValue.Interface()); err != nil {
+					if err := l.unmarshal(rawReturnValue.value, returnValue.Interface()); err != nil {
 						panic(err)
 					}
 				}
@@ -219,10 +568,29 @@ func (r Registry[R, T]) makeRPC(
 				valueReturnValue := reflect.New(functionType.Out(0))
 				errReturnValue := reflect.New(functionType.Out(1))
 
-				if !rawReturnValue.cancelled {
-					if err := unmarshal(rawReturnValue.value, valueReturnValue.Interface()); err != nil {
+				if stream != nil {
+					if rawReturnValue.err != nil {
+						l.streams.freeIncoming(callID)
+					} else {
+						valueReturnValue.Elem().Set(l.receiveStream(ctx, stream, functionType.Out(0), func(stopped bool) {
+							l.streams.freeIncoming(callID)
+
+							if !stopped {
+								return
+							}
+
+							// If the consumer stopped receiving values, let the remote know so that it can stop sending them.
+							// The link might already be closed, in which case the stream has ended anyways.
+							_ = l.writeCancel(callID)
+						}))
+					}
+				} else if !rawReturnValue.cancelled {
+					v, err := r.unmarshalReturnValue(l, rawReturnValue.value, functionType.Out(0))
+					if err != nil {
 						panic(err)
 					}
+
+					valueReturnValue.Elem().Set(v)
 				}
 
 				if rawReturnValue.err != nil {
@@ -230,29 +598,56 @@ func (r Registry[R, T]) makeRPC(
 				}
 
 				returnValues = append(returnValues, valueReturnValue.Elem(), errReturnValue.Elem())
+			} else {
+				// Multiple values are sent as an array
+				rawValues := []T{}
+				if !rawReturnValue.cancelled && rawReturnValue.err == nil {
+					if err := l.unmarshal(rawReturnValue.value, &rawValues); err != nil {
+						panic(err)
+					}
+
+					if len(rawValues) != functionType.NumOut()-1 {
+						panic(ErrInvalidReturn)
+					}
+				}
+
+				for i := 0; i < functionType.NumOut()-1; i++ {
+					valueReturnValue := reflect.New(functionType.Out(i))
+
+					if i < len(rawValues) {
+						v, err := r.unmarshalReturnValue(l, rawValues[i], functionType.Out(i))
+						if err != nil {
+							panic(err)
+						}
+
+						valueReturnValue.Elem().Set(v)
+					}
+
+					returnValues = append(returnValues, valueReturnValue.Elem())
+				}
+
+				errReturnValue := reflect.New(functionType.Out(functionType.NumOut() - 1))
+				if rawReturnValue.err != nil {
+					errReturnValue.Elem().Set(reflect.ValueOf(rawReturnValue.err))
+				}
+
+				returnValues = append(returnValues, errReturnValue.Elem())
 			}
-		case <-linkCtx.Done():
-			panic(linkCtx.Err())
+		case <-l.ctx.Done():
+			panic(l.ctx.Err())
 		}
 
 		return returnValues
-	})
+	}), l.hooks.ClientInterceptors)
 }
 
 func (r Registry[R, T]) implementRemoteStructRecursively(
-	ctx context.Context,
+	l *link[T],
 
+	target string,
 	namePrefix string,
 
 	remote reflect.Value,
-
-	setErr func(err error),
-	responseResolver *utils.Broadcaster[callResponse[T]],
-
-	writeRequest func(b T) error,
-
-	marshal func(v any) (T, error),
-	unmarshal func(data T, v any) error,
 ) error {
 	for i := 0; i < remote.NumField(); i++ {
 		functionField := remote.Type().Field(i)
@@ -265,19 +660,12 @@ func (r Registry[R, T]) implementRemoteStructRecursively(
 
 		if functionType.Kind() == reflect.Struct {
 			if err := r.implementRemoteStructRecursively(
-				ctx,
+				l,
 
+				target,
 				namePrefix+prefix+functionField.Name,
 
 				remote.FieldByName(functionField.Name),
-
-				setErr,
-				responseResolver,
-
-				writeRequest,
-
-				marshal,
-				unmarshal,
 			); err != nil {
 				return err
 			}
@@ -289,7 +677,7 @@ func (r Registry[R, T]) implementRemoteStructRecursively(
 			continue
 		}
 
-		if functionType.NumOut() <= 0 || functionType.NumOut() > 2 {
+		if functionType.NumOut() <= 0 {
 			return ErrInvalidReturn
 		}
 
@@ -305,20 +693,50 @@ func (r Registry[R, T]) implementRemoteStructRecursively(
 			return ErrInvalidArgs
 		}
 
+		returnsRef := functionType.NumOut() == 2 &&
+			functionType.Out(0).Kind() == reflect.Ptr &&
+			functionType.Out(0).Implements(refType)
+
+		// References can only be implemented for structs of functions
+		if returnsRef && reflect.New(functionType.Out(0).Elem()).Interface().(ref).objectType().Kind() != reflect.Struct {
+			return ErrInvalidReturn
+		}
+
+		mode := getCallMode(functionField)
+
+		// Only calls that return references can be pipelined
+		if mode == callModePipeline && !returnsRef {
+			return ErrInvalidReturn
+		}
+
+		// Notifications can't return values since the remote doesn't respond to them
+		if mode == callModeNotify && functionType.NumOut() != 1 {
+			return ErrInvalidReturn
+		}
+
+		// Closures passed to notifications would be released as soon as the request has been sent; callbacks need to be used instead
+		if mode == callModeNotify {
+			for i := 1; i < functionType.NumIn(); i++ {
+				argType := functionType.In(i)
+				if getStreamKind(argType) != streamKindNone || isSendChan(argType) || argType.Implements(callbackType) {
+					continue
+				}
+
+				if _, containsFuncs := getShadowType(argType); containsFuncs {
+					return ErrClosureInNotification
+				}
+			}
+		}
+
 		remote.
 			FieldByName(functionField.Name).
 			Set(r.makeRPC(
-				ctx,
+				l,
 
+				target,
 				namePrefix+prefix+functionField.Name,
 				functionType,
-				setErr,
-				responseResolver,
-
-				writeRequest,
-
-				marshal,
-				unmarshal,
+				mode,
 			))
 	}
 
@@ -326,162 +744,546 @@ func (r Registry[R, T]) implementRemoteStructRecursively(
 }
 
 func (r Registry[R, T]) findLocalFunctionToCallRecursively(
-	ctx context.Context,
-
-	req utils.Request[T],
-
-	setErr func(err error),
-	responseResolver *utils.Broadcaster[callResponse[T]],
-
-	writeRequest func(b T) error,
+	l *link[T],
 
-	marshal func(v any) (T, error),
-	unmarshal func(data T, v any) error,
+	callCtx context.Context, // Context of this call, which is cancelled if the caller gives up on it
 
-	remoteID string,
+	req utils.Request[T],
+	queued bool, // Whether the call was queued until a pipelined call has returned, in which case it can't take streams
 ) (
 	function reflect.Value,
 	args []reflect.Value,
+	finish func(), // Function to call once the function has returned, which ends the streams it could send values to
 
 	err error,
 ) {
-	function, err = findMethodByFunctionCallPathRecursively(r.local.wrappee, req.Function)
-	if err != nil {
+	var finishers []func()
+	finish = func() {
+		for _, finisher := range finishers {
+			finisher()
+		}
+	}
+
+	rawArgs := req.Args
+
+	if req.Target != "" {
+		// Calls to objects that were returned by reference are resolved to the object's methods only
+		object, err := l.references.getObject(req.Target)
+		if err != nil {
+			return function, args, finish, err
+		}
+
+		function, err = findMethodByFunctionCallPathRecursively(object, req.Function)
+		if err != nil {
+			return function, args, finish, err
+		}
+	} else {
+		function, err = r.findRegisteredFunction(req.Function)
+		if err != nil {
+			function, err = findMethodByFunctionCallPathRecursively(l.local, req.Function)
+		}
+	}
+
+	if err != nil && req.Function == "CallClosure" {
+		// Calls to closures are resolved to the closure itself so that its arguments can be unmarshalled into their real types
+		if len(req.Args) != 2 {
+			return function, args, finish, ErrInvalidArgsCount
+		}
+
+		closureID := ""
+		if err := l.unmarshal(req.Args[0], &closureID); err != nil {
+			return function, args, finish, errors.Join(ErrInvalidArg, err)
+		}
+
+		rawArgs = nil
+		if err := l.unmarshal(req.Args[1], &rawArgs); err != nil {
+			return function, args, finish, errors.Join(ErrInvalidArg, err)
+		}
+
+		function, err = l.closures.getClosure(closureID)
+		if err != nil {
+			return function, args, finish, err
+		}
+	} else if err != nil {
 		function, err = reflect.
-			ValueOf(r.local.wrapper).
+			ValueOf(l.closures).
 			MethodByName(req.Function), nil
 
 		if function.Kind() != reflect.Func {
-			return function, args, errors.Join(ErrCannotCallNonFunction, err)
+			function = reflect.
+				ValueOf(l.references).
+				MethodByName(req.Function)
+		}
+
+		if function.Kind() != reflect.Func {
+			return function, args, finish, errors.Join(ErrCannotCallNonFunction, err)
+		}
+	}
+
+	// Variadic arguments are sent as individual arguments
+	if function.Type().IsVariadic() {
+		if len(rawArgs)+1 < function.Type().NumIn()-1 {
+			return function, args, finish, ErrInvalidArgsCount
 		}
+	} else if function.Type().NumIn() != len(rawArgs)+1 {
+		return function, args, finish, ErrInvalidArgsCount
 	}
 
-	if function.Type().NumIn() != len(req.Args)+1 {
-		return function, args, ErrInvalidArgsCount
+	if queued {
+		// Values of streams passed to queued calls could have arrived before the streams were registered, so the streams are ended right away
+		ended, err := l.endStreamArgs(function.Type(), rawArgs, ErrStreamToQueuedCall)
+		if err != nil {
+			return function, args, finish, err
+		}
+
+		if ended {
+			return function, args, finish, ErrStreamToQueuedCall
+		}
 	}
 
-	for i := 0; i < function.Type().NumIn(); i++ {
+	for i := 0; i < len(rawArgs)+1; i++ {
 		if i == 0 {
 			// Add the context to the function arguments
-			args = append(args, reflect.ValueOf(context.WithValue(ctx, RemoteIDContextKey, remoteID)))
+			args = append(args, reflect.ValueOf(l.callContext(callCtx)))
 
 			continue
 		}
 
-		functionType := function.Type().In(i)
+		functionType := getParamType(function.Type(), i)
 		argIndex := i - 1 // Capture the argument index
 
-		if functionType.Kind() == reflect.Func {
-			arg := reflect.MakeFunc(functionType, func(args []reflect.Value) (results []reflect.Value) {
-				defer func() {
-					var err error
-					if e := recover(); e != nil {
-						var ok bool
-						err, ok = e.(error)
-						if !ok {
-							err = utils.ErrPanickedWithNonErrorValue
-						}
+		if getStreamKind(functionType) != streamKindNone || isSendChan(functionType) {
+			streamID := ""
+			if err := l.unmarshal(rawArgs[argIndex], &streamID); err != nil {
+				return function, args, finish, errors.Join(ErrInvalidArg, err)
+			}
+
+			if isSendChan(functionType) {
+				// Values that the function sends to the channel are sent to the caller until the function has returned
+				ch := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, functionType.Elem()), 0)
+				outgoing := l.streams.registerOutgoing(streamID)
+
+				var (
+					stop = make(chan struct{})
+					done = make(chan struct{})
+				)
+				go func() {
+					defer close(done)
+
+					if err := l.sendStream(callCtx, streamID, ch, outgoing, l.streamWriter(false), stop); err != nil {
+						l.setErr(err)
+					}
+				}()
+
+				finishers = append(finishers, func() {
+					close(stop)
+
+					<-done
+				})
+
+				args = append(args, ch.Convert(functionType))
+			} else {
+				incoming := l.streams.registerIncoming(streamID, l.streamWriter(false))
+
+				args = append(args, l.receiveStream(callCtx, incoming, functionType, l.releaseStream(streamID, false)))
+			}
+		} else if functionType.Kind() == reflect.Ptr && functionType.Implements(callbackType) {
+			closureID := ""
+			if err := l.unmarshal(rawArgs[argIndex], &closureID); err != nil {
+				return function, args, finish, errors.Join(ErrInvalidArg, err)
+			}
+
+			arg, err := r.makeCallback(l, closureID, functionType)
+			if err != nil {
+				return function, args, finish, errors.Join(ErrInvalidArg, err)
+			}
+
+			args = append(args, arg)
+		} else {
+			arg, err := r.unmarshalWithClosures(l, rawArgs[argIndex], functionType)
+			if err != nil {
+				return function, args, finish, errors.Join(ErrInvalidArg, err)
+			}
+
+			args = append(args, arg)
+		}
+	}
+
+	return
+}
+
+// makeRef implements a reference of type `t` to the object with the ID `referenceID` on the remote
+func (r Registry[R, T]) makeRef(
+	l *link[T],
+
+	referenceID string,
+	t reflect.Type,
+) (reflect.Value, error) {
+	v := reflect.New(t.Elem())
+	rf := v.Interface().(ref)
+
+	object := reflect.New(rf.objectType()).Elem()
+	if err := r.implementRemoteStructRecursively(
+		l,
+
+		referenceID,
+		"",
+
+		object,
+	); err != nil {
+		return reflect.Value{}, err
+	}
+
+	release := r.makeRPC(
+		l,
+
+		"",
+		"ReleaseReference",
+		reflect.TypeOf(releaseReferenceType(nil)),
+		callModeDefault,
+	).Interface().(releaseReferenceType)
+
+	rf.setRemote(object, func(ctx context.Context) error {
+		return release(ctx, referenceID)
+	})
+
+	return v, nil
+}
+
+// encodeReturnValue prepares the return value `v` of a local function for being marshalled. Objects returned by reference
+// stay registered until the remote releases them or the link is closed and are sent as their reference IDs, and closures
+// that are returned stay registered until the link is closed.
+func encodeReturnValue[T any](l *link[T], v reflect.Value) (value any, referenceID string, err error) {
+	if cb, ok := v.Interface().(callback); ok && v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", "", nil
+		}
+
+		// Callbacks stay registered until the remote releases them or the link is closed
+		closureID, fn := cb.closure()
+		if err := registerCallback(l.closures, closureID, fn); err != nil {
+			return nil, "", err
+		}
+
+		return closureID, "", nil
+	}
+
+	if rf, ok := v.Interface().(ref); ok {
+		if v.IsNil() {
+			return "", "", nil
+		}
+
+		referenceID, object := rf.reference()
+		registerReference(l.references, referenceID, object)
+
+		return referenceID, referenceID, nil
+	}
+
+	encoded, err := encodeClosuresForReturn(l, v)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return encoded.Interface(), "", nil
+}
+
+// callContext returns the context that is passed to a local function for a call with the context `callCtx`
+func (l *link[T]) callContext(callCtx context.Context) context.Context {
+	return context.WithValue(context.WithValue(callCtx, RemoteIDContextKey, l.remoteID), PrincipalContextKey, l.principal)
+}
+
+// encodeResults prepares the results `res` of a local function for being sent as a response. Functions without
+// results only signal that they have returned, and multiple values are sent as an array. Panics while encoding
+// the results are returned as errors.
+func encodeResults[T any](l *link[T], res []reflect.Value) (value any, referenceID string, stream reflect.Value, callErr error) {
+	defer func() {
+		if e := recover(); e != nil {
+			value, referenceID, stream = nil, "", reflect.Value{}
+			callErr = &utils.PanicError{
+				Value: e,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
+
+	switch len(res) {
+	case 0:
+		return nil, "", reflect.Value{}, nil
+	case 1:
+		if res[0].Type().Implements(errorType) {
+			if !res[0].IsNil() {
+				callErr = res[0].Interface().(error)
+			}
+		} else {
+			value, _, err := encodeReturnValue(l, res[0])
+			if err != nil {
+				return nil, "", reflect.Value{}, err
+			}
+
+			return value, "", reflect.Value{}, nil
+		}
+	case 2:
+		if !res[1].IsNil() {
+			callErr = res[1].Interface().(error)
+		}
+
+		if getStreamKind(res[0].Type()) != streamKindNone {
+			if callErr == nil {
+				stream = res[0]
+			}
+		} else {
+			value, referenceID, err := encodeReturnValue(l, res[0])
+			if err != nil {
+				return nil, "", reflect.Value{}, err
+			}
+
+			if callErr != nil {
+				referenceID = ""
+			}
+
+			return value, referenceID, reflect.Value{}, callErr
+		}
+	default:
+		if !res[len(res)-1].IsNil() {
+			callErr = res[len(res)-1].Interface().(error)
+		}
+
+		// Multiple values are sent as an array
+		values := []any{}
+		for _, v := range res[:len(res)-1] {
+			value, _, err := encodeReturnValue(l, v)
+			if err != nil {
+				return nil, "", reflect.Value{}, err
+			}
+
+			values = append(values, value)
+		}
+
+		value = values
+	}
+
+	return value, referenceID, stream, callErr
+}
+
+// unmarshalReturnValue unmarshals the return value `data` of a remote function into a value of type `t`.
+// References are sent as reference IDs and implemented as references to the objects on the remote.
+func (r Registry[R, T]) unmarshalReturnValue(
+	l *link[T],
+
+	data T,
+	t reflect.Type,
+) (reflect.Value, error) {
+	if t.Kind() == reflect.Ptr && t.Implements(callbackType) {
+		closureID := ""
+		if err := l.unmarshal(data, &closureID); err != nil {
+			return reflect.Value{}, err
+		}
+
+		if closureID == "" {
+			return reflect.Zero(t), nil
+		}
+
+		return r.makeCallback(l, closureID, t)
+	}
+
+	if !t.Implements(refType) {
+		return r.unmarshalWithClosures(l, data, t)
+	}
+
+	referenceID := ""
+	if err := l.unmarshal(data, &referenceID); err != nil {
+		return reflect.Value{}, err
+	}
+
+	if referenceID == "" {
+		return reflect.Zero(t), nil
+	}
+
+	return r.makeRef(l, referenceID, t)
+}
+
+// unmarshalWithClosures unmarshals `data` into a value of type `t`. Closures, including those nested in structs,
+// slices and maps, are sent as closure IDs and implemented as functions that call the closures on the remote.
+func (r Registry[R, T]) unmarshalWithClosures(
+	l *link[T],
+
+	data T,
+	t reflect.Type,
+) (reflect.Value, error) {
+	shadow, _ := getShadowType(t)
+
+	v := reflect.New(shadow)
+	if err := l.unmarshal(data, v.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+
+	return decodeClosures(v.Elem(), t, func(closureID string, functionType reflect.Type) reflect.Value {
+		return r.makeClosureRPC(l, closureID, functionType)
+	}), nil
+}
+
+// makeCallback implements a callback of type `t`, which is a `*Callback[F]`, that calls the closure with the ID `closureID` on the remote
+// and releases it on the remote once it is released
+func (r Registry[R, T]) makeCallback(
+	l *link[T],
+
+	closureID string,
+	t reflect.Type,
+) (reflect.Value, error) {
+	v := reflect.New(t.Elem())
+	cb := v.Interface().(callback)
+
+	if cb.functionType().Kind() != reflect.Func {
+		return reflect.Value{}, ErrNotAFunction
+	}
+
+	release := r.makeRPC(
+		l,
+
+		"",
+		"ReleaseClosure",
+		reflect.TypeOf(releaseClosureType(nil)),
+		callModeDefault,
+	).Interface().(releaseClosureType)
+
+	cb.setRemote(r.makeClosureRPC(l, closureID, cb.functionType()), func(ctx context.Context) error {
+		return release(ctx, closureID)
+	})
 
-						setErr(err)
-					}
+	return v, nil
+}
 
-					// If we tried to return with an invalid results count, set them so that the call doesn't panic
-					if len(results) != functionType.NumOut() {
-						errReturnValue := reflect.ValueOf(err)
+// makeClosureRPC implements a function of type `functionType` that calls the closure with the ID `closureID` on the remote
+func (r Registry[R, T]) makeClosureRPC(
+	l *link[T],
 
-						if functionType.NumOut() == 1 {
-							results = []reflect.Value{errReturnValue}
-						} else if functionType.NumOut() == 2 {
-							valueReturnValue := reflect.Zero(functionType.Out(0))
+	closureID string,
+	functionType reflect.Type,
+) reflect.Value {
+	// The arguments are marshalled individually and the return value is unmarshalled into its real type, so any value that can be marshalled can be used
+	rpcReturnTypes := []reflect.Type{}
+	for i := 0; i < functionType.NumOut()-1; i++ {
+		rpcReturnTypes = append(rpcReturnTypes, functionType.Out(i))
+	}
+	rpcReturnTypes = append(rpcReturnTypes, errorType)
 
-							results = []reflect.Value{valueReturnValue, errReturnValue}
-						}
-					}
-				}()
+	rpc := r.makeRPC(
+		l,
 
-				closureID := ""
-				if err := unmarshal(req.Args[argIndex], &closureID); err != nil {
-					panic(err)
+		"",
+		"CallClosure",
+		reflect.FuncOf([]reflect.Type{contextType, reflect.TypeOf(""), reflect.TypeOf([]T{})}, rpcReturnTypes, false),
+		callModeDefault,
+	)
+
+	return reflect.MakeFunc(functionType, func(args []reflect.Value) (results []reflect.Value) {
+		defer func() {
+			var err error
+			if e := recover(); e != nil {
+				var ok bool
+				err, ok = e.(error)
+				if !ok {
+					err = utils.ErrPanickedWithNonErrorValue
 				}
 
-				rpc := r.makeRPC(
-					ctx,
+				l.setErr(err)
+			}
 
-					"CallClosure",
-					reflect.TypeOf(callClosureType(nil)),
-					setErr,
-					responseResolver,
+			// If we tried to return with an invalid results count, set them so that the call doesn't panic
+			if len(results) != functionType.NumOut() {
+				results = makeErrorResults(functionType, err)
+			}
+		}()
 
-					writeRequest,
+		var (
+			ctx     context.Context
+			rpcArgs = []T{}
 
-					marshal,
-					unmarshal,
-				)
+			// Closures passed as arguments are freed once the call has returned
+			freeClosures []func()
+		)
+		defer func() {
+			for _, freeClosure := range freeClosures {
+				freeClosure()
+			}
+		}()
+		for i, arg := range flattenVariadicArgs(functionType, args) {
+			if i == 0 {
+				v, ok := arg.Interface().(context.Context)
+				if !ok {
+					panic(ErrInvalidArgs)
+				}
+				ctx = v
 
-				var (
-					ctx     context.Context
-					rpcArgs = []interface{}{}
-				)
-				for i, arg := range args {
-					if i == 0 {
-						v, ok := arg.Interface().(context.Context)
-						if !ok {
-							panic(ErrInvalidArgs)
-						}
-						ctx = v
+				// Don't sent the context over the wire
+				continue
+			}
 
-						// Don't sent the context over the wire
-						continue
-					}
+			v, err := encodeClosuresForCall(l, arg, &freeClosures)
+			if err != nil {
+				panic(err)
+			}
 
-					rpcArgs = append(rpcArgs, arg.Interface())
-				}
+			b, err := l.marshal(v.Interface())
+			if err != nil {
+				panic(err)
+			}
 
-				rcpRv, err := utils.Call(rpc, []reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(closureID), reflect.ValueOf(rpcArgs)})
-				if err != nil {
-					panic(err)
-				}
+			rpcArgs = append(rpcArgs, b)
+		}
 
-				rv := []reflect.Value{}
-				if functionType.NumOut() == 1 {
-					returnValue := reflect.New(functionType.Out(0))
+		rpcRv, err := utils.Call(rpc, []reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(closureID), reflect.ValueOf(rpcArgs)})
+		if err != nil {
+			panic(err)
+		}
 
-					returnValue.Elem().Set(rcpRv[1]) // Error return value is at index 1
+		errReturnValue := reflect.New(functionType.Out(functionType.NumOut() - 1))
+		if rpcErr := rpcRv[len(rpcRv)-1]; !rpcErr.IsNil() {
+			errReturnValue.Elem().Set(rpcErr.Elem())
+		}
 
-					rv = append(rv, returnValue.Elem())
-				} else if functionType.NumOut() == 2 {
-					valueReturnValue := reflect.New(functionType.Out(0))
-					errReturnValue := reflect.New(functionType.Out(1))
+		return append(rpcRv[:len(rpcRv)-1], errReturnValue.Elem())
+	})
+}
 
-					if el := rcpRv[0].Elem(); el.IsValid() {
-						convertedValueReturnType, err := convertValue(el, valueReturnValue.Type().Elem())
-						if err != nil {
-							panic(err)
-						}
+// makeErrorResults returns the zero values of the return values of `functionType`, except for the trailing error, which is set to `err`
+func makeErrorResults(functionType reflect.Type, err error) []reflect.Value {
+	results := []reflect.Value{}
+	for i := 0; i < functionType.NumOut()-1; i++ {
+		results = append(results, reflect.Zero(functionType.Out(i)))
+	}
 
-						valueReturnValue.Elem().Set(convertedValueReturnType)
-					}
-					errReturnValue.Elem().Set(rcpRv[1])
+	errReturnValue := reflect.New(functionType.Out(functionType.NumOut() - 1)).Elem()
+	if err != nil {
+		errReturnValue.Set(reflect.ValueOf(err))
+	}
 
-					rv = append(rv, valueReturnValue.Elem(), errReturnValue.Elem())
-				}
+	return append(results, errReturnValue)
+}
 
-				return rv
-			})
+// flattenVariadicArgs returns `args` with the variadic arguments of `functionType` as individual arguments, which is how they are sent to the remote
+func flattenVariadicArgs(functionType reflect.Type, args []reflect.Value) []reflect.Value {
+	if !functionType.IsVariadic() || len(args) == 0 {
+		return args
+	}
 
-			args = append(args, arg)
-		} else {
-			arg := reflect.New(functionType)
+	variadicArgs := args[len(args)-1]
 
-			if err := unmarshal(req.Args[argIndex], arg.Interface()); err != nil {
-				return function, args, err
-			}
+	flattenedArgs := append([]reflect.Value{}, args[:len(args)-1]...)
+	for i := 0; i < variadicArgs.Len(); i++ {
+		flattenedArgs = append(flattenedArgs, variadicArgs.Index(i))
+	}
 
-			args = append(args, arg.Elem())
-		}
+	return flattenedArgs
+}
+
+// getParamType returns the type of the argument at `index` when calling a function of type `functionType`, taking variadic arguments into account
+func getParamType(functionType reflect.Type, index int) reflect.Type {
+	if functionType.IsVariadic() && index >= functionType.NumIn()-1 {
+		return functionType.In(functionType.NumIn() - 1).Elem()
 	}
 
-	return
+	return functionType.In(index)
 }
 
 func findMethodByFunctionCallPathRecursively(root interface{}, functionCallPath string) (reflect.Value, error) {
@@ -557,7 +1359,7 @@ func (r Registry[R, T]) LinkMessage(
 	unmarshal func(data T, v any) error, // Function to unmarshal nested values with
 
 	hooks *LinkHooks, // Link hooks
-) error {
+) (err error) {
 	if hooks == nil {
 		hooks = &LinkHooks{}
 	}
@@ -613,11 +1415,15 @@ func (r Registry[R, T]) LinkMessage(
 	// don't wait for all goroutines to have exited. It is the job
 	// of the caller to clean those up by making sure that the read/write
 	// functions return errors - e.g. by closing the connection
+	streams := newStreamManager[T]()
+
 	setErr := func(err error) {
 		if err == nil {
 			responseResolver.Close(context.Canceled)
+			streams.close(context.Canceled)
 		} else {
 			responseResolver.Close(err)
+			streams.close(err)
 		}
 
 		fatalErrLock.L.Lock()
@@ -634,32 +1440,103 @@ func (r Registry[R, T]) LinkMessage(
 		setErr(ctx.Err())
 	}()
 
+	remoteID := uuid.NewString()
+
+	var (
+		// Cancellation functions for the contexts of in-flight calls, indexed by call ID
+		calls     = map[string]context.CancelFunc{}
+		callsLock sync.Mutex
+
+		// Contexts of calls are derived from this context, which is cancelled once the link is closed
+		callsCtx, cancelCalls = context.WithCancel(ctx)
+		inFlightCalls         sync.WaitGroup
+	)
+	defer cancelCalls()
+
+	// If the local RPCs are created for each link, they are disposed of once the link is closed.
+	// Functions with other signatures than `func(remoteID string) any` are exposed as local RPCs themselves.
+	local := r.local
+	if factory, ok := r.local.(func(remoteID string) any); ok {
+		local = factory(remoteID)
+
+		if closer, ok := local.(interface{ Close() error }); ok {
+			defer func() {
+				// The in-flight calls could still be using the local RPCs, so they are cancelled and closed once they have returned
+				callsLock.Lock()
+				cancelCalls()
+				callsLock.Unlock()
+
+				inFlightCalls.Wait()
+
+				if closeErr := closer.Close(); closeErr != nil {
+					err = errors.Join(err, closeErr)
+				}
+			}()
+		}
+	}
+
+	l := &link[T]{
+		ctx: ctx,
+
+		remoteID: remoteID,
+
+		hooks: mergeHooks(r.hooks, hooks),
+
+		local: local,
+
+		closures: &closureManager{
+			closuresLock: sync.Mutex{},
+			closures:     map[string]reflect.Value{},
+		},
+
+		references: &referenceManager{
+			objectsLock: sync.Mutex{},
+			objects:     map[string]any{},
+			references:  map[string]int{},
+			promises:    map[string]*promise{},
+		},
+
+		setErr:           setErr,
+		responseResolver: responseResolver,
+		streams:          streams,
+
+		writeRequest:  writeRequestCtx,
+		writeResponse: writeResponseCtx,
+
+		marshal:   marshal,
+		unmarshal: unmarshal,
+	}
+
 	go func() {
+		// The remote is verified before the local RPCs are exposed to it or it is made available to `ForRemotes`
+		principal, readNextRequest, err := l.handshake(readRequestCtx, readResponseCtx, l.hooks.Credentials, l.hooks.VerifyCredentials)
+		if err != nil {
+			setErr(err)
+
+			return
+		}
+		l.principal = principal
+
 		if err := r.implementRemoteStructRecursively(
-			ctx,
+			l,
 
+			"",
 			"",
 
 			remote,
-
-			setErr,
-			responseResolver,
-
-			writeRequestCtx,
-
-			marshal,
-			unmarshal,
 		); err != nil {
 			setErr(err)
 		}
 
-		remoteID := uuid.NewString()
-
 		r.remotesLock.Lock()
 		r.remotes[remoteID] = remote.Interface().(R)
 
-		if r.hooks.OnClientConnect != nil {
-			r.hooks.OnClientConnect(remoteID)
+		r.linksLock.Lock()
+		r.links[remoteID] = l
+		r.linksLock.Unlock()
+
+		if l.hooks.OnClientConnect != nil {
+			l.hooks.OnClientConnect(remoteID)
 		}
 
 		r.remotesLock.Unlock()
@@ -668,13 +1545,227 @@ func (r Registry[R, T]) LinkMessage(
 			r.remotesLock.Lock()
 			delete(r.remotes, remoteID)
 
-			if r.hooks.OnClientDisconnect != nil {
-				r.hooks.OnClientDisconnect(remoteID)
+			r.linksLock.Lock()
+			delete(r.links, remoteID)
+			r.linksLock.Unlock()
+
+			if l.hooks.OnClientDisconnect != nil {
+				l.hooks.OnClientDisconnect(remoteID)
 			}
 
 			r.remotesLock.Unlock()
 		}()
 
+		// handleCall calls the local function for `req` and writes its result with `writeCallResponse`, after which it calls `done`.
+		// It needs to be called before the next request is read so that streams passed as arguments are registered before their values arrive.
+		handleCall := func(
+			req utils.Request[T],
+
+			writeCallResponse func(callID string, value any, callErr error, metadata Metadata) error,
+			done func(),
+
+			batched bool, // Whether the call is part of a batch
+		) {
+			var (
+				err error
+
+				callCtx       context.Context
+				cancelCallCtx context.CancelFunc
+			)
+			if req.Deadline > 0 {
+				callCtx, cancelCallCtx = context.WithDeadline(callsCtx, time.Now().Add(time.Duration(req.Deadline)*time.Millisecond))
+			} else {
+				callCtx, cancelCallCtx = context.WithCancel(callsCtx)
+			}
+
+			callsLock.Lock()
+			if callsCtx.Err() != nil {
+				// Calls aren't dispatched anymore once the link is closed
+				callsLock.Unlock()
+
+				cancelCallCtx()
+				done()
+
+				return
+			}
+
+			calls[req.Call] = cancelCallCtx
+			inFlightCalls.Add(1)
+			callsLock.Unlock()
+
+			// The local function can read the metadata of the call and set the metadata of the response through its context
+			rm := &responseMetadata{}
+			callCtx = context.WithValue(callCtx, IncomingMetadataContextKey, Metadata(req.Metadata))
+			callCtx = context.WithValue(callCtx, responseMetadataContextKey, rm)
+
+			if req.Pipeline {
+				// This needs to happen before the next request is read so that calls that target the result of this call can be queued
+				registerPromise(l.references, req.Call)
+			}
+
+			var (
+				function reflect.Value
+				args     []reflect.Value
+				finish   = func() {}
+
+				// Calls that target the result of a pipelined call are queued until the pipelined call has returned
+				targetPromise = l.references.getPromise(req.Target)
+			)
+			if targetPromise != nil {
+				select {
+				case <-targetPromise.done:
+					// Calls that target the result of a pipelined call that has already returned don't need to be queued
+					req.Target, err = targetPromise.referenceID, targetPromise.err
+					targetPromise = nil
+
+				default:
+				}
+			}
+
+			if targetPromise == nil && err == nil {
+				// Remotes that may not call the function can't learn anything about its arguments or pass streams to it
+				err = r.checkPolicies(l, callCtx, req, nil)
+			}
+
+			if targetPromise == nil && err == nil {
+				function, args, finish, err = r.findLocalFunctionToCallRecursively(
+					l,
+
+					callCtx,
+
+					req,
+					false,
+				)
+			}
+
+			go func() {
+				defer func() {
+					callsLock.Lock()
+					delete(calls, req.Call)
+					callsLock.Unlock()
+
+					cancelCallCtx()
+
+					done()
+
+					inFlightCalls.Done()
+				}()
+
+				var (
+					promiseReferenceID string
+					promiseErr         = ErrReferenceDoesNotExist
+				)
+				if req.Pipeline {
+					defer func() {
+						l.references.resolvePromise(req.Call, promiseReferenceID, promiseErr)
+					}()
+				}
+
+				if targetPromise != nil {
+					req.Target, err = targetPromise.wait(callCtx)
+					if err == nil {
+						function, args, finish, err = r.findLocalFunctionToCallRecursively(
+							l,
+
+							callCtx,
+
+							req,
+							true,
+						)
+					}
+				}
+
+				if err == nil {
+					err = r.checkPolicies(l, callCtx, req, args)
+				}
+
+				if err == nil && batched && function.Type().NumOut() == 2 && getStreamKind(function.Type().Out(0)) != streamKindNone {
+					// The results of the calls in a batch are sent together, so they can't return streams
+					err = ErrStreamInBatch
+				}
+
+				if err != nil {
+					finish()
+					promiseErr = err
+
+					// Notifications don't have a response
+					if req.Notify {
+						return
+					}
+
+					// A bad call only fails the call itself, not the entire link
+					if err := writeCallResponse(req.Call, nil, err, rm.get()); err != nil {
+						setErr(err)
+					}
+
+					return
+				}
+
+				res, err := utils.Call(intercept(remoteID, req.Function, function, l.hooks.ServerInterceptors), args)
+				finish()
+				if err != nil {
+					promiseErr = err
+
+					// A panic in an RPC only fails the call itself, not the entire link
+					var panicErr *utils.PanicError
+					if errors.As(err, &panicErr) && l.hooks.OnPanic != nil {
+						l.hooks.OnPanic(remoteID, req.Function, panicErr.Value, panicErr.Stack)
+					}
+
+					if req.Notify {
+						return
+					}
+
+					if err := writeCallResponse(req.Call, nil, err, rm.get()); err != nil {
+						setErr(err)
+					}
+
+					return
+				}
+
+				// The return values of notifications are discarded
+				if req.Notify {
+					return
+				}
+
+				// Encoding the results only fails the call itself, not the entire link
+				value, referenceID, stream, callErr := encodeResults(l, res)
+				if referenceID != "" {
+					promiseReferenceID, promiseErr = referenceID, nil
+				}
+
+				var panicErr *utils.PanicError
+				if errors.As(callErr, &panicErr) && l.hooks.OnPanic != nil {
+					l.hooks.OnPanic(remoteID, req.Function, panicErr.Value, panicErr.Stack)
+				}
+
+				if callErr != nil {
+					promiseReferenceID, promiseErr = "", callErr
+				}
+
+				// Register the stream so that the caller can grant credits for it
+				var outgoing *outgoingStream
+				if stream.IsValid() {
+					outgoing = l.streams.registerOutgoing(req.Call)
+				}
+
+				if err := writeCallResponse(req.Call, value, callErr, rm.get()); err != nil {
+					setErr(err)
+
+					return
+				}
+
+				if stream.IsValid() {
+					// The call's context stays valid until the stream has ended or the caller has stopped receiving values
+					if err := l.sendStream(callCtx, req.Call, stream, outgoing, l.streamWriter(false), nil); err != nil {
+						setErr(err)
+
+						return
+					}
+				}
+			}()
+		}
+
 		var wg sync.WaitGroup
 
 		wg.Add(1)
@@ -682,7 +1773,7 @@ func (r Registry[R, T]) LinkMessage(
 			defer wg.Done()
 
 			for {
-				b, err := readRequestCtx()
+				b, err := readNextRequest()
 				if err != nil {
 					setErr(err)
 
@@ -696,167 +1787,81 @@ func (r Registry[R, T]) LinkMessage(
 					return
 				}
 
-				go func() {
-					function, args, err := r.findLocalFunctionToCallRecursively(
-						ctx,
-
-						req,
-
-						setErr,
-						responseResolver,
+				if req.Stream != nil {
+					l.handleStreamMessage(req.Stream)
 
-						writeRequestCtx,
-
-						marshal,
-						unmarshal,
+					continue
+				}
 
-						remoteID,
-					)
-					if err != nil {
+				// Credentials are only verified when the link is established, so later handshakes or ones that aren't verified are accepted
+				if req.Handshake {
+					if err := l.writeCallResponse(req.Call, nil, nil, nil); err != nil {
 						setErr(err)
 
 						return
 					}
 
-					go func() {
-						res, err := utils.Call(function, args)
-						if err != nil {
-							setErr(err)
-
-							return
-						}
-
-						switch len(res) {
-						case 0:
-							v, err := marshal(nil)
-							if err != nil {
-								setErr(err)
-
-								return
-							}
+					continue
+				}
 
-							res := &utils.Response[T]{
-								Call:  req.Call,
-								Value: v,
-								Err:   "",
-							}
+				if req.Cancel {
+					callsLock.Lock()
+					cancelCall, ok := calls[req.Call]
+					callsLock.Unlock()
 
-							b, err := res.Marshal(marshal)
-							if err != nil {
-								setErr(err)
+					if ok {
+						cancelCall()
+					}
 
-								return
-							}
+					continue
+				}
 
-							if err := writeResponseCtx(b); err != nil {
-								setErr(err)
+				if len(req.Batch) > 0 {
+					// The calls in a batch are dispatched concurrently, and their results are sent back together in the same order
+					var (
+						responses = make([]utils.Response[T], len(req.Batch))
+						batchWg   sync.WaitGroup
+					)
+					for i, batchReq := range req.Batch {
+						i := i // Capture the index
 
-								return
-							}
-						case 1:
-							if res[0].Type().Implements(errorType) && !res[0].IsNil() {
-								v, err := marshal(nil)
-								if err != nil {
-									setErr(err)
-
-									return
-								}
-
-								res := &utils.Response[T]{
-									Call:  req.Call,
-									Value: v,
-									Err:   res[0].Interface().(error).Error(),
-								}
-
-								b, err := res.Marshal(marshal)
-								if err != nil {
-									setErr(err)
-
-									return
-								}
-
-								if err := writeResponseCtx(b); err != nil {
-									setErr(err)
-
-									return
-								}
-							} else {
-								v, err := marshal(res[0].Interface())
-								if err != nil {
-									setErr(err)
-
-									return
-								}
-
-								res := &utils.Response[T]{
-									Call:  req.Call,
-									Value: v,
-									Err:   "",
-								}
-
-								b, err := res.Marshal(marshal)
-								if err != nil {
-									setErr(err)
-
-									return
-								}
-
-								if err := writeResponseCtx(b); err != nil {
-									setErr(err)
-
-									return
-								}
-							}
-						case 2:
-							v, err := marshal(res[0].Interface())
+						batchWg.Add(1)
+						handleCall(batchReq, func(callID string, value any, callErr error, metadata Metadata) error {
+							res, err := l.encodeCallResponse(callID, value, callErr, metadata)
 							if err != nil {
-								setErr(err)
-
-								return
+								return err
 							}
 
-							if res[1].Interface() == nil {
-								res := &utils.Response[T]{
-									Call:  req.Call,
-									Value: v,
-									Err:   "",
-								}
-
-								b, err := res.Marshal(marshal)
-								if err != nil {
-									setErr(err)
-
-									return
-								}
+							responses[i] = *res
 
-								if err := writeResponseCtx(b); err != nil {
-									setErr(err)
+							return nil
+						}, batchWg.Done, true)
+					}
 
-									return
-								}
-							} else {
-								res := &utils.Response[T]{
-									Call:  req.Call,
-									Value: v,
-									Err:   res[1].Interface().(error).Error(),
-								}
+					go func() {
+						batchWg.Wait()
 
-								b, err := marshal(res)
-								if err != nil {
-									setErr(err)
+						res := &utils.Response[T]{
+							Call:  req.Call,
+							Batch: responses,
+						}
 
-									return
-								}
+						b, err := res.Marshal(l.marshal)
+						if err != nil {
+							setErr(err)
 
-								if err := writeResponseCtx(b); err != nil {
-									setErr(err)
+							return
+						}
 
-									return
-								}
-							}
+						if err := l.writeResponse(b); err != nil {
+							setErr(err)
 						}
 					}()
-				}()
+
+					continue
+				}
+
+				handleCall(req, l.writeCallResponse, func() {}, false)
 			}
 		}()
 
@@ -879,11 +1884,18 @@ func (r Registry[R, T]) LinkMessage(
 					return
 				}
 
+				// Stream messages need to be handled in order, so they can't be published asynchronously
+				if res.Stream != nil {
+					l.handleStreamMessage(res.Stream)
+
+					continue
+				}
+
 				if strings.TrimSpace(res.Err) != "" {
-					err = errors.New(res.Err)
+					err = decodeError(res.Err, res.Code, res.Details, unmarshal)
 				}
 
-				go responseResolver.Publish(res.Call, callResponse[T]{res.Value, err, false})
+				go responseResolver.Publish(res.Call, callResponse[T]{res.Value, err, false, res.Batch, res.Metadata})
 			}
 		}()
 
@@ -891,7 +1903,7 @@ func (r Registry[R, T]) LinkMessage(
 	}()
 
 	fatalErrLock.L.Lock()
-	err := fatalErr
+	err = fatalErr
 	if err == nil {
 		fatalErrLock.Wait()
 
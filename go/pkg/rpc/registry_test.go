@@ -8,10 +8,14 @@ import (
 	"io"
 	"net"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/pojntfx/panrpc/go/pkg/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -219,9 +223,26 @@ func (s *returnValueLocal) TestValueAndError(ctx context.Context, shouldError bo
 	return atomic.AddInt64(&s.counter, 1), nil
 }
 
+func (s *returnValueLocal) TestRegisteredError(ctx context.Context) (int64, error) {
+	return 0, fmt.Errorf("could not get value: %w", errRegisteredTest)
+}
+
+func (s *returnValueLocal) TestPanic(ctx context.Context) (int64, error) {
+	panic("string panic")
+}
+
 type returnValueRemote struct {
-	TestSingleError   func(ctx context.Context, shouldError bool) error
-	TestValueAndError func(ctx context.Context, shouldError bool) (int64, error)
+	TestSingleError     func(ctx context.Context, shouldError bool) error
+	TestValueAndError   func(ctx context.Context, shouldError bool) (int64, error)
+	TestRegisteredError func(ctx context.Context) (int64, error)
+	TestPanic           func(ctx context.Context) (int64, error)
+}
+
+type badCallRemote struct {
+	TestSingleError     func(ctx context.Context, shouldError bool, extra string) error
+	TestValueAndError   func(ctx context.Context, shouldError string) (int64, error)
+	TestUnknown         func(ctx context.Context) error
+	TestRegisteredError func(ctx context.Context) (int64, error)
 }
 
 type remoteOnlyFields struct {
@@ -240,6 +261,24 @@ type remoteNoErrorReturn struct {
 	TestFuncNoError func() bool
 }
 
+type remoteInvalidPipeline struct {
+	TestFuncNoReference func(ctx context.Context) (string, error) `panrpc:"pipeline"`
+}
+
+type remoteInvalidNotify struct {
+	TestFuncWithValue func(ctx context.Context) (string, error) `panrpc:"notify"`
+}
+
+type remoteInvalidNotifyClosure struct {
+	TestFuncWithClosure func(ctx context.Context, options struct {
+		OnDone func(ctx context.Context) error
+	}) error `panrpc:"notify"`
+}
+
+type remoteNotifyCallback struct {
+	TestFuncWithCallback func(ctx context.Context, onDone *Callback[func(ctx context.Context) error]) error `panrpc:"notify"`
+}
+
 type remoteNoInputs struct {
 	TestFuncNoInputs func() error
 }
@@ -289,658 +328,4049 @@ type methodFinderMultiLevel struct {
 	*methodFinderNestedPtr
 }
 
-func setupConnection(t *testing.T) (net.Listener, *sync.WaitGroup, *sync.WaitGroup) {
-	lis, err := net.Listen("tcp", "localhost:0")
-	require.NoError(t, err)
-
-	var serverConnected, clientConnected sync.WaitGroup
-	serverConnected.Add(1)
-	clientConnected.Add(1)
+type cancellationServerLocal struct {
+	callStarted   chan struct{}
+	callCancelled chan struct{}
 
-	return lis, &serverConnected, &clientConnected
+	deadlineErrs chan error
 }
 
-func startServer[R, L any](t *testing.T, ctx context.Context, lis net.Listener, serverLocal L, serverConnected *sync.WaitGroup) (*Registry[R, json.RawMessage], *sync.WaitGroup) {
-	serverRegistry := NewRegistry[R, json.RawMessage](
-		serverLocal,
+func (s *cancellationServerLocal) TestBlock(ctx context.Context) error {
+	close(s.callStarted)
 
-		&RegistryHooks{
-			OnClientConnect: func(remoteID string) {
-				serverConnected.Done()
-			},
-		},
-	)
+	<-ctx.Done()
 
-	var serverDone sync.WaitGroup
-	serverDone.Add(1)
+	close(s.callCancelled)
+
+	return ctx.Err()
+}
+
+func (s *cancellationServerLocal) TestBlockingClosure(ctx context.Context, onWait func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	go func() {
-		defer serverDone.Done()
+		<-s.callStarted
 
-		conn, err := lis.Accept()
-		require.NoError(t, err)
+		cancel()
+	}()
 
-		defer conn.Close()
+	return onWait(ctx)
+}
 
-		linkCtx, cancelLinkCtx := context.WithCancel(ctx)
-		defer cancelLinkCtx()
+func (s *cancellationServerLocal) TestWaitForDeadline(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		s.deadlineErrs <- nil
 
-		encoder := json.NewEncoder(conn)
-		decoder := json.NewDecoder(conn)
+		return nil
+	}
 
-		if err := serverRegistry.LinkStream(
-			linkCtx,
+	<-ctx.Done()
 
-			func(v Message[json.RawMessage]) error {
-				return encoder.Encode(v)
-			},
-			func(v *Message[json.RawMessage]) error {
-				return decoder.Decode(v)
-			},
+	s.deadlineErrs <- ctx.Err()
 
-			func(v any) (json.RawMessage, error) {
-				b, err := json.Marshal(v)
-				if err != nil {
-					return nil, err
-				}
-				return json.RawMessage(b), nil
-			},
-			func(data json.RawMessage, v any) error {
-				return json.Unmarshal([]byte(data), v)
-			},
+	return ctx.Err()
+}
 
-			nil,
-		); err != nil && !errors.Is(err, io.EOF) {
+type cancellationServerRemote struct {
+	TestBlock           func(ctx context.Context) error
+	TestWaitForDeadline func(ctx context.Context) error
+	TestBlockingClosure func(ctx context.Context, onWait func(ctx context.Context) error) error
+}
+
+type streamingServerLocal struct {
+	streamCancelled chan struct{}
+
+	slowConsumerStarted chan struct{}
+	slowConsumerRelease chan struct{}
+}
+
+func (s *streamingServerLocal) TestChannel(ctx context.Context, count int) (<-chan int, error) {
+	ch := make(chan int)
+
+	go func() {
+		defer close(ch)
+
+		for i := 0; i < count; i++ {
 			select {
+			case ch <- i:
 			case <-ctx.Done():
 				return
-			default:
 			}
-
-			require.NoError(t, err)
 		}
 	}()
 
-	return serverRegistry, &serverDone
+	return ch, nil
 }
 
-func startClient[R, L any](t *testing.T, ctx context.Context, addr string, clientLocal L, clientConnected *sync.WaitGroup) (*Registry[R, json.RawMessage], *sync.WaitGroup) {
-	clientRegistry := NewRegistry[R, json.RawMessage](
-		clientLocal,
-
-		&RegistryHooks{
-			OnClientConnect: func(remoteID string) {
-				clientConnected.Done()
-			},
-		},
-	)
-
-	conn, err := net.Dial("tcp", addr)
-	require.NoError(t, err)
-
-	var clientDone sync.WaitGroup
-	clientDone.Add(1)
-
-	go func() {
-		defer clientDone.Done()
-		defer conn.Close()
-
-		linkCtx, cancelLinkCtx := context.WithCancel(ctx)
-		defer cancelLinkCtx()
+func (s *streamingServerLocal) TestIterator(ctx context.Context, count int) (func(yield func(int) bool), error) {
+	return func(yield func(int) bool) {
+		for i := 0; i < count; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}, nil
+}
 
-		encoder := json.NewEncoder(conn)
-		decoder := json.NewDecoder(conn)
+func (s *streamingServerLocal) TestIteratorWithError(ctx context.Context) (func(yield func(int, error) bool), error) {
+	return func(yield func(int, error) bool) {
+		for i := 0; i < 2; i++ {
+			if !yield(i, nil) {
+				return
+			}
+		}
 
-		if err := clientRegistry.LinkStream(
-			linkCtx,
+		yield(0, fmt.Errorf("could not continue: %w", errRegisteredTest))
+	}, nil
+}
 
-			func(v Message[json.RawMessage]) error {
-				return encoder.Encode(v)
-			},
-			func(v *Message[json.RawMessage]) error {
-				return decoder.Decode(v)
-			},
+func (s *streamingServerLocal) TestStreamError(ctx context.Context) (<-chan int, error) {
+	return nil, errTest
+}
 
-			func(v any) (json.RawMessage, error) {
-				b, err := json.Marshal(v)
-				if err != nil {
-					return nil, err
-				}
-				return json.RawMessage(b), nil
-			},
+func (s *streamingServerLocal) TestEndless(ctx context.Context) (<-chan int, error) {
+	ch := make(chan int)
 
-			func(data json.RawMessage, v any) error {
-				return json.Unmarshal([]byte(data), v)
-			},
+	go func() {
+		defer close(ch)
 
-			nil,
-		); err != nil {
+		for i := 0; ; i++ {
 			select {
+			case ch <- i:
 			case <-ctx.Done():
+				close(s.streamCancelled)
+
 				return
-			default:
 			}
-
-			require.NoError(t, err)
 		}
 	}()
 
-	return clientRegistry, &clientDone
+	return ch, nil
 }
 
-func TestSimpleRPCFromClientToServer(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	lis, serverConnected, clientConnected := setupConnection(t)
-	defer lis.Close()
-
-	_, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, &serverLocal{}, serverConnected)
-	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), &clientLocal{}, clientConnected)
-
-	// Wait for client to connect to server
-	serverConnected.Wait()
-	clientConnected.Wait()
+func (s *streamingServerLocal) TestSum(ctx context.Context, values <-chan int) (int, error) {
+	sum := 0
+	for value := range values {
+		sum += value
+	}
 
-	// Test client calling server
-	err := clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
-		count, err := remote.TestSimple(ctx, 1)
-		require.NoError(t, err)
-		require.Equal(t, int64(1), count)
+	return sum, nil
+}
 
-		count, err = remote.TestSimple(ctx, 2)
-		require.NoError(t, err)
-		require.Equal(t, int64(3), count)
+func (s *streamingServerLocal) TestSumIterator(ctx context.Context, values func(yield func(int) bool)) (int, error) {
+	sum := 0
+	values(func(value int) bool {
+		sum += value
 
-		return nil
+		return true
 	})
-	require.NoError(t, err)
 
-	cancel()
-	clientDone.Wait()
-	serverDone.Wait()
+	return sum, nil
 }
 
-func TestSimpleRPCFromServerToClient(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+func (s *streamingServerLocal) TestCount(ctx context.Context, count int, values chan<- int) error {
+	for i := 0; i < count; i++ {
+		select {
+		case values <- i:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 
-	lis, serverConnected, clientConnected := setupConnection(t)
-	defer lis.Close()
+	return nil
+}
 
-	cl := &clientLocal{}
-	cl.messageReceived.Add(1) // Expect one message
+func (s *streamingServerLocal) TestDouble(ctx context.Context, in <-chan int, out chan<- int) error {
+	for value := range in {
+		select {
+		case out <- value * 2:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 
-	serverRegistry, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, &serverLocal{}, serverConnected)
-	_, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+	return nil
+}
 
-	// Wait for client to connect to server
-	serverConnected.Wait()
-	clientConnected.Wait()
+func (s *streamingServerLocal) TestSlowConsumer(ctx context.Context, values <-chan int) error {
+	<-values
 
-	// Test server calling client
-	err := serverRegistry.ForRemotes(func(remoteID string, remote clientRemote) error {
-		err := remote.TestSimple(ctx, "test message")
-		require.NoError(t, err)
-		return nil
-	})
-	require.NoError(t, err)
+	close(s.slowConsumerStarted)
 
-	// Wait for message to be received
-	cl.messageReceived.Wait()
+	<-s.slowConsumerRelease
 
-	cancel()
-	clientDone.Wait()
-	serverDone.Wait()
+	return nil
+}
 
-	// Verify results
-	require.Len(t, cl.messages, 1)
-	require.Equal(t, "test message", cl.messages[0])
+type streamingServerRemote struct {
+	TestChannel           func(ctx context.Context, count int) (<-chan int, error)
+	TestIterator          func(ctx context.Context, count int) (func(yield func(int) bool), error)
+	TestIteratorWithError func(ctx context.Context) (func(yield func(int, error) bool), error)
+	TestStreamError       func(ctx context.Context) (<-chan int, error)
+	TestEndless           func(ctx context.Context) (<-chan int, error)
+
+	TestSum          func(ctx context.Context, values <-chan int) (int, error)
+	TestSumIterator  func(ctx context.Context, values func(yield func(int) bool)) (int, error)
+	TestCount        func(ctx context.Context, count int, values chan<- int) error
+	TestDouble       func(ctx context.Context, in <-chan int, out chan<- int) error
+	TestSlowConsumer func(ctx context.Context, values <-chan int) error
 }
 
-func TestServerRPCWithCallbackToClient(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// streamingInvalidServerRemote passes streams to functions that don't exist or with invalid arguments
+type streamingInvalidServerRemote struct {
+	TestMissing     func(ctx context.Context, values chan<- int) error
+	TestCount       func(ctx context.Context, count string, values chan<- int) error
+	TestSumIterator func(ctx context.Context, values func(yield func(int) bool), extra int) (int, error)
+}
 
-	lis, serverConnected, clientConnected := setupConnection(t)
-	defer lis.Close()
+type callbackServerLocal struct {
+	subscriberLock sync.Mutex
+	subscriber     *Callback[func(ctx context.Context, value int) error]
+}
 
-	cl := &clientLocal{}
-	cl.messageReceived.Add(1) // Expect one message
+func (s *callbackServerLocal) Subscribe(ctx context.Context, onValue *Callback[func(ctx context.Context, value int) error]) error {
+	s.subscriberLock.Lock()
+	defer s.subscriberLock.Unlock()
 
-	sl := &serverLocal{}
+	s.subscriber = onValue
 
-	serverRegistry, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, sl, serverConnected)
-	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+	return nil
+}
 
-	cl.ForRemotes = clientRegistry.ForRemotes
-	sl.ForRemotes = serverRegistry.ForRemotes
+func (s *callbackServerLocal) Publish(ctx context.Context, value int) error {
+	s.subscriberLock.Lock()
+	defer s.subscriberLock.Unlock()
 
-	// Wait for client to connect to server
-	serverConnected.Wait()
-	clientConnected.Wait()
+	return s.subscriber.Fn()(ctx, value)
+}
 
-	iterations := 5
-	err := clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
-		length, err := remote.TestCallback(ctx, int64(iterations))
-		require.NoError(t, err)
-		require.Equal(t, length, int64(iterations))
-		return nil
-	})
-	require.NoError(t, err)
+func (s *callbackServerLocal) Unsubscribe(ctx context.Context) error {
+	s.subscriberLock.Lock()
+	defer s.subscriberLock.Unlock()
 
-	// Wait for message to be received
-	cl.messageReceived.Wait()
+	return s.subscriber.Release(ctx)
+}
 
-	cancel()
-	clientDone.Wait()
-	serverDone.Wait()
+type closureEvent struct {
+	Name  string
+	Count int
+}
 
-	// Verify results
-	require.Len(t, cl.messages, 1)
-	require.Equal(t, fmt.Sprintf("Incrementing counter by %v", iterations), cl.messages[0])
+type closureEventResult struct {
+	Accepted bool
+	Reason   string
 }
 
-func TestServerRPCWithCallbackToClientWithError(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+type complexClosureServerLocal struct{}
 
-	lis, serverConnected, clientConnected := setupConnection(t)
-	defer lis.Close()
+func (s *complexClosureServerLocal) TestComplexClosure(
+	ctx context.Context,
+	onEvent func(ctx context.Context, event closureEvent, tags map[string]int, note *string) (*closureEventResult, error),
+) (closureEventResult, error) {
+	note := "This is from the callee"
 
-	cl := &clientLocal{}
+	res, err := onEvent(ctx, closureEvent{Name: "brewed", Count: 2}, map[string]int{"latte": 1, "mocca": 1}, &note)
+	if err != nil {
+		return closureEventResult{}, err
+	}
 
-	sl := &serverLocal{}
+	return *res, nil
+}
 
-	serverRegistry, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, sl, serverConnected)
-	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+type closureHandlers struct {
+	Name   string
+	OnData func(ctx context.Context, data string) error
+	OnDone func(ctx context.Context) error
+	OnSkip func(ctx context.Context) error
+	Nested *closureNestedHandlers
+}
+
+type closureNestedHandlers struct {
+	OnDone func(ctx context.Context) error
+}
+
+func (s *complexClosureServerLocal) TestNestedClosures(
+	ctx context.Context,
+	handlers closureHandlers,
+	steps []func(ctx context.Context) error,
+	hooks map[string]func(ctx context.Context, i int) (int, error),
+) (int, error) {
+	if err := handlers.OnData(ctx, handlers.Name); err != nil {
+		return -1, err
+	}
+
+	if err := handlers.OnDone(ctx); err != nil {
+		return -1, err
+	}
+
+	if handlers.OnSkip != nil {
+		return -1, errors.New("nil closure was not nil")
+	}
+
+	if err := handlers.Nested.OnDone(ctx); err != nil {
+		return -1, err
+	}
+
+	for _, step := range steps {
+		if err := step(ctx); err != nil {
+			return -1, err
+		}
+	}
+
+	return hooks["double"](ctx, len(steps))
+}
+
+type complexClosureServerRemote struct {
+	TestNestedClosures func(
+		ctx context.Context,
+		handlers closureHandlers,
+		steps []func(ctx context.Context) error,
+		hooks map[string]func(ctx context.Context, i int) (int, error),
+	) (int, error)
+
+	TestComplexClosure func(
+		ctx context.Context,
+		onEvent func(ctx context.Context, event closureEvent, tags map[string]int, note *string) (*closureEventResult, error),
+	) (closureEventResult, error)
+}
+
+type returnedClosureServerLocal struct {
+	subscribers atomic.Int64
+}
+
+func (s *returnedClosureServerLocal) Subscribe(ctx context.Context) (func(ctx context.Context) (int64, error), error) {
+	s.subscribers.Add(1)
+
+	return func(ctx context.Context) (int64, error) {
+		return s.subscribers.Add(-1), nil
+	}, nil
+}
+
+func (s *returnedClosureServerLocal) SubscribeCallback(ctx context.Context) (*Callback[func(ctx context.Context) (int64, error)], error) {
+	s.subscribers.Add(1)
+
+	return NewCallback(func(ctx context.Context) (int64, error) {
+		return s.subscribers.Add(-1), nil
+	}), nil
+}
+
+func (s *returnedClosureServerLocal) GetHandlers(ctx context.Context, name string) (*closureNestedHandlers, error) {
+	return &closureNestedHandlers{
+		OnDone: func(ctx context.Context) error {
+			if name == "" {
+				return errors.New("missing name")
+			}
+
+			return nil
+		},
+	}, nil
+}
+
+type returnedClosureServerRemote struct {
+	Subscribe         func(ctx context.Context) (func(ctx context.Context) (int64, error), error)
+	SubscribeCallback func(ctx context.Context) (*Callback[func(ctx context.Context) (int64, error)], error)
+	GetHandlers       func(ctx context.Context, name string) (*closureNestedHandlers, error)
+}
+
+type referenceRoom struct {
+	name string
+
+	messagesLock sync.Mutex
+	messages     []string
+
+	Moderation referenceRoomModeration
+}
+
+func (r *referenceRoom) SendMessage(ctx context.Context, message string) error {
+	r.messagesLock.Lock()
+	defer r.messagesLock.Unlock()
+
+	r.messages = append(r.messages, r.name+": "+message)
+
+	return nil
+}
+
+func (r *referenceRoom) GetMessages(ctx context.Context) ([]string, error) {
+	r.messagesLock.Lock()
+	defer r.messagesLock.Unlock()
+
+	return r.messages, nil
+}
+
+type referenceRoomModeration struct {
+	room *referenceRoom
+}
+
+func (m referenceRoomModeration) Clear(ctx context.Context) error {
+	m.room.messagesLock.Lock()
+	defer m.room.messagesLock.Unlock()
+
+	m.room.messages = nil
+
+	return nil
+}
+
+type referenceServerLocal struct{}
+
+func (s *referenceServerLocal) OpenRoom(ctx context.Context, name string) (*Ref[*referenceRoom], error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	room := &referenceRoom{name: name}
+	room.Moderation.room = room
+
+	return NewRef(room), nil
+}
+
+type referenceRoomRemote struct {
+	SendMessage func(ctx context.Context, message string) error
+	GetMessages func(ctx context.Context) ([]string, error)
+
+	Moderation struct {
+		Clear func(ctx context.Context) error
+	}
+}
+
+type referenceServerRemote struct {
+	OpenRoom func(ctx context.Context, name string) (*Ref[referenceRoomRemote], error)
+}
+
+type pipelineTable struct {
+	rows map[string]string
+}
+
+func (t *pipelineTable) Get(ctx context.Context, key string) (string, error) {
+	return t.rows[key], nil
+}
+
+type pipelineDatabase struct {
+	tables map[string]*pipelineTable
+}
+
+func (d *pipelineDatabase) Table(ctx context.Context, name string) (*Ref[*pipelineTable], error) {
+	table, ok := d.tables[name]
+	if !ok {
+		return nil, errors.New("table does not exist")
+	}
+
+	return NewRef(table), nil
+}
+
+func (d *pipelineDatabase) Sum(ctx context.Context, values <-chan int) (int, error) {
+	sum := 0
+	for value := range values {
+		sum += value
+	}
+
+	return sum, nil
+}
+
+func (d *pipelineDatabase) Double(ctx context.Context, in <-chan int, out chan<- int) error {
+	for value := range in {
+		out <- value * 2
+	}
+
+	return nil
+}
+
+type pipelineServerLocal struct {
+	opened chan struct{}
+}
+
+func (s *pipelineServerLocal) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *pipelineServerLocal) OpenDatabase(ctx context.Context, name string) (*Ref[*pipelineDatabase], error) {
+	// Block until the caller has sent all pipelined calls
+	<-s.opened
+
+	if name == "" {
+		return nil, errors.New("missing name")
+	}
+
+	return NewRef(&pipelineDatabase{
+		tables: map[string]*pipelineTable{
+			"users": {
+				rows: map[string]string{
+					"1": "Alice",
+				},
+			},
+		},
+	}), nil
+}
+
+type pipelineTableRemote struct {
+	Get func(ctx context.Context, key string) (string, error)
+}
+
+type pipelineDatabaseRemote struct {
+	Table  func(ctx context.Context, name string) (*Ref[pipelineTableRemote], error) `panrpc:"pipeline"`
+	Sum    func(ctx context.Context, values <-chan int) (int, error)
+	Double func(ctx context.Context, in <-chan int, out chan<- int) error
+}
+
+type pipelineServerRemote struct {
+	OpenDatabase func(ctx context.Context, name string) (*Ref[pipelineDatabaseRemote], error) `panrpc:"pipeline"`
+	Ping         func(ctx context.Context) error
+}
+
+type notificationServerLocal struct {
+	messages chan string
+}
+
+func (s *notificationServerLocal) Log(ctx context.Context, message string) error {
+	s.messages <- message
+
+	return nil
+}
+
+func (s *notificationServerLocal) Fail(ctx context.Context) error {
+	return errors.New("notification failed")
+}
+
+func (s *notificationServerLocal) Ping(ctx context.Context) (string, error) {
+	return "pong", nil
+}
+
+type notificationServerRemote struct {
+	Log  func(ctx context.Context, message string) error `panrpc:"notify"`
+	Fail func(ctx context.Context) error                 `panrpc:"notify"`
+	Ping func(ctx context.Context) (string, error)
+}
+
+type batchServerLocal struct {
+	rendezvous sync.WaitGroup
+}
+
+func (s *batchServerLocal) Double(ctx context.Context, i int) (int, error) {
+	return i * 2, nil
+}
+
+func (s *batchServerLocal) Token(ctx context.Context) (string, error) {
+	return GetIncomingMetadata(ctx)["token"], nil
+}
+
+func (s *batchServerLocal) Fail(ctx context.Context) error {
+	return errors.New("batch call failed")
+}
+
+func (s *batchServerLocal) Apply(ctx context.Context, i int, fn func(ctx context.Context, i int) (int, error)) (int, error) {
+	return fn(ctx, i)
+}
+
+func (s *batchServerLocal) Stream(ctx context.Context) (<-chan int, error) {
+	return make(chan int), nil
+}
+
+func (s *batchServerLocal) Rendezvous(ctx context.Context) error {
+	// Only returns if the other call to `Rendezvous` is dispatched concurrently
+	s.rendezvous.Done()
+	s.rendezvous.Wait()
+
+	return nil
+}
+
+type variadicServerLocal struct{}
+
+func (s *variadicServerLocal) Join(ctx context.Context, separator string, parts ...string) (string, error) {
+	return strings.Join(parts, separator), nil
+}
+
+func (s *variadicServerLocal) Divide(ctx context.Context, a, b int) (int, int, error) {
+	if b == 0 {
+		return 0, 0, errors.New("division by zero")
+	}
+
+	return a / b, a % b, nil
+}
+
+func (s *variadicServerLocal) Apply(
+	ctx context.Context,
+	fn func(ctx context.Context, prefix string, values ...int) (string, int, error),
+) (string, int, error) {
+	return fn(ctx, "sum", 1, 2, 3)
+}
+
+func (s *variadicServerLocal) Ping(ctx context.Context) {}
+
+type variadicServerRemote struct {
+	Ping   func(ctx context.Context) error
+	Join   func(ctx context.Context, separator string, parts ...string) (string, error)
+	Divide func(ctx context.Context, a, b int) (int, int, error)
+	Apply  func(
+		ctx context.Context,
+		fn func(ctx context.Context, prefix string, values ...int) (string, int, error),
+	) (string, int, error)
+}
+
+type sessionServerLocal struct {
+	remoteID string
+	counter  int
+
+	closed chan string
+}
+
+func (s *sessionServerLocal) Increment(ctx context.Context) (int, error) {
+	s.counter++
+
+	return s.counter, nil
+}
+
+func (s *sessionServerLocal) GetRemoteID(ctx context.Context) (string, error) {
+	return s.remoteID, nil
+}
+
+func (s *sessionServerLocal) Close() error {
+	s.closed <- s.remoteID
+
+	return nil
+}
+
+type sessionServerRemote struct {
+	Increment   func(ctx context.Context) (int, error)
+	GetRemoteID func(ctx context.Context) (string, error)
+}
+
+type inFlightServerLocal struct {
+	started  chan struct{}
+	returned atomic.Bool
+
+	closed chan bool
+}
+
+func (s *inFlightServerLocal) Wait(ctx context.Context) error {
+	close(s.started)
+
+	<-ctx.Done()
+
+	// Give a premature `Close` the chance to run before the call has returned
+	time.Sleep(10 * time.Millisecond)
+	s.returned.Store(true)
+
+	return ctx.Err()
+}
+
+func (s *inFlightServerLocal) Close() error {
+	s.closed <- s.returned.Load()
+
+	return nil
+}
+
+type inFlightServerRemote struct {
+	Wait func(ctx context.Context) error
+}
+
+type metadataServerLocal struct{}
+
+func (s *metadataServerLocal) Echo(ctx context.Context, key string) (string, error) {
+	SetResponseMetadata(ctx, Metadata{"echoed": key})
+
+	return GetIncomingMetadata(ctx)[key], nil
+}
+
+type metadataServerRemote struct {
+	Echo func(ctx context.Context, key string) (string, error)
+}
+
+type principalServerLocal struct{}
+
+func (s *principalServerLocal) GetPrincipal(ctx context.Context) (string, error) {
+	principal, _ := GetPrincipal(ctx).(string)
+
+	return principal, nil
+}
+
+type principalServerRemote struct {
+	GetPrincipal func(ctx context.Context) (string, error)
+}
+
+type policyAdminLocal struct{}
+
+func (s *policyAdminLocal) Delete(ctx context.Context, id string) (string, error) {
+	return id, nil
+}
+
+func (s *policyAdminLocal) Import(ctx context.Context, ids <-chan string) (int, error) {
+	count := 0
+	for range ids {
+		count++
+	}
+
+	return count, nil
+}
+
+func (s *policyAdminLocal) Export(ctx context.Context, ids chan<- string) error {
+	ids <- "1"
+
+	return nil
+}
+
+type policyPublicLocal struct{}
+
+func (s *policyPublicLocal) Read(ctx context.Context) (string, error) {
+	return "public", nil
+}
+
+type policyServerLocal struct {
+	Admin  *policyAdminLocal `panrpc:"policy=admin"`
+	Public *policyPublicLocal
+}
+
+func (s *policyServerLocal) Apply(ctx context.Context, fn func(ctx context.Context) (string, error)) (string, error) {
+	return fn(ctx)
+}
+
+type policyServerRemote struct {
+	Admin struct {
+		Delete func(ctx context.Context, id string) (string, error)
+	}
+	Public struct {
+		Read func(ctx context.Context) (string, error)
+	}
+	Apply func(ctx context.Context, fn func(ctx context.Context) (string, error)) (string, error)
+}
+
+// policyMismatchedServerRemote calls `Admin.Delete` with a different signature than the server's
+type policyMismatchedServerRemote struct {
+	Admin struct {
+		Delete func(ctx context.Context) (string, error)
+		Import func(ctx context.Context, ids <-chan string) (int, error)
+		Export func(ctx context.Context, ids chan<- string) error
+	}
+}
+
+type policyPromotingServerLocal struct {
+	*policyAdminLocal `panrpc:"policy=admin"`
+	policyPublicLocal
+}
+
+type policyPromotingServerRemote struct {
+	Delete func(ctx context.Context, id string) (string, error)
+	Read   func(ctx context.Context) (string, error)
+}
+
+type peerServerLocal struct{}
+
+func (s *peerServerLocal) GetPeer(ctx context.Context) (string, string, error) {
+	remoteID, ok := RemoteIDFromContext(ctx)
+	if !ok || remoteID == "" {
+		return "", "", errors.New("missing remote ID")
+	}
+
+	peer, ok := PeerFromContext(ctx)
+	if !ok {
+		return "", "", errors.New("missing peer")
+	}
+
+	return peer.Transport, peer.RemoteAddr.String(), nil
+}
+
+type peerServerRemote struct {
+	GetPeer func(ctx context.Context) (string, string, error)
+}
+
+type isolationClientLocal struct {
+	closureIDs      chan string
+	releaseClosures chan struct{}
+}
+
+// TestLeakClosure takes the ID of a closure instead of the closure itself
+func (c *isolationClientLocal) TestLeakClosure(ctx context.Context, closureID string) error {
+	c.closureIDs <- closureID
+
+	<-c.releaseClosures
+
+	return nil
+}
+
+type isolationClientRemote struct {
+	TestLeakClosure func(ctx context.Context, onCall func(ctx context.Context) error) error
+}
+
+type isolationServerRemote struct {
+	CallClosure func(ctx context.Context, closureID string, args []interface{}) (interface{}, error)
+}
+
+type callbackServerRemote struct {
+	Subscribe   func(ctx context.Context, onValue *Callback[func(ctx context.Context, value int) error]) error
+	Publish     func(ctx context.Context, value int) error
+	Unsubscribe func(ctx context.Context) error
+}
+
+func setupConnection(t *testing.T) (net.Listener, *sync.WaitGroup, *sync.WaitGroup) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	var serverConnected, clientConnected sync.WaitGroup
+	serverConnected.Add(1)
+	clientConnected.Add(1)
+
+	return lis, &serverConnected, &clientConnected
+}
+
+func startServer[R, L any](t *testing.T, ctx context.Context, lis net.Listener, serverLocal L, serverConnected *sync.WaitGroup) (*Registry[R, json.RawMessage], *sync.WaitGroup) {
+	return startServerWithHooks[R](t, ctx, lis, serverLocal, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			serverConnected.Done()
+		},
+	})
+}
+
+func startServerWithHooks[R, L any](t *testing.T, ctx context.Context, lis net.Listener, serverLocal L, hooks *RegistryHooks) (*Registry[R, json.RawMessage], *sync.WaitGroup) {
+	serverRegistry := NewRegistry[R, json.RawMessage](
+		serverLocal,
+
+		hooks,
+	)
+
+	return serverRegistry, acceptLink(t, ctx, lis, serverRegistry)
+}
+
+// acceptLink accepts a single connection and links it to the registry
+func acceptLink[R any](t *testing.T, ctx context.Context, lis net.Listener, serverRegistry *Registry[R, json.RawMessage]) *sync.WaitGroup {
+	var serverDone sync.WaitGroup
+	serverDone.Add(1)
+
+	go func() {
+		defer serverDone.Done()
+
+		conn, err := lis.Accept()
+		require.NoError(t, err)
+
+		defer conn.Close()
+
+		linkCtx, cancelLinkCtx := context.WithCancel(ctx)
+		defer cancelLinkCtx()
+
+		encoder := json.NewEncoder(conn)
+		decoder := json.NewDecoder(conn)
+
+		if err := serverRegistry.LinkStream(
+			linkCtx,
+
+			func(v Message[json.RawMessage]) error {
+				return encoder.Encode(v)
+			},
+			func(v *Message[json.RawMessage]) error {
+				return decoder.Decode(v)
+			},
+
+			func(v any) (json.RawMessage, error) {
+				b, err := json.Marshal(v)
+				if err != nil {
+					return nil, err
+				}
+				return json.RawMessage(b), nil
+			},
+			func(data json.RawMessage, v any) error {
+				return json.Unmarshal([]byte(data), v)
+			},
+
+			nil,
+		); err != nil && !errors.Is(err, io.EOF) {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			require.NoError(t, err)
+		}
+	}()
+
+	return &serverDone
+}
+
+func startClient[R, L any](t *testing.T, ctx context.Context, addr string, clientLocal L, clientConnected *sync.WaitGroup) (*Registry[R, json.RawMessage], *sync.WaitGroup) {
+	return startClientWithHooks[R](t, ctx, addr, clientLocal, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			clientConnected.Done()
+		},
+	})
+}
+
+func startClientWithHooks[R, L any](t *testing.T, ctx context.Context, addr string, clientLocal L, hooks *RegistryHooks) (*Registry[R, json.RawMessage], *sync.WaitGroup) {
+	clientRegistry := NewRegistry[R, json.RawMessage](
+		clientLocal,
+
+		hooks,
+	)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+
+	var clientDone sync.WaitGroup
+	clientDone.Add(1)
+
+	go func() {
+		defer clientDone.Done()
+		defer conn.Close()
+
+		linkCtx, cancelLinkCtx := context.WithCancel(ctx)
+		defer cancelLinkCtx()
+
+		encoder := json.NewEncoder(conn)
+		decoder := json.NewDecoder(conn)
+
+		if err := clientRegistry.LinkStream(
+			linkCtx,
+
+			func(v Message[json.RawMessage]) error {
+				return encoder.Encode(v)
+			},
+			func(v *Message[json.RawMessage]) error {
+				return decoder.Decode(v)
+			},
+
+			func(v any) (json.RawMessage, error) {
+				b, err := json.Marshal(v)
+				if err != nil {
+					return nil, err
+				}
+				return json.RawMessage(b), nil
+			},
+
+			func(data json.RawMessage, v any) error {
+				return json.Unmarshal([]byte(data), v)
+			},
+
+			nil,
+		); err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			require.NoError(t, err)
+		}
+	}()
+
+	return clientRegistry, &clientDone
+}
+
+func TestSimpleRPCFromClientToServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, &serverLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), &clientLocal{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	// Test client calling server
+	err := clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
+		count, err := remote.TestSimple(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), count)
+
+		count, err = remote.TestSimple(ctx, 2)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), count)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestSimpleRPCFromServerToClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	cl := &clientLocal{}
+	cl.messageReceived.Add(1) // Expect one message
+
+	serverRegistry, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, &serverLocal{}, serverConnected)
+	_, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	// Test server calling client
+	err := serverRegistry.ForRemotes(func(remoteID string, remote clientRemote) error {
+		err := remote.TestSimple(ctx, "test message")
+		require.NoError(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Wait for message to be received
+	cl.messageReceived.Wait()
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+
+	// Verify results
+	require.Len(t, cl.messages, 1)
+	require.Equal(t, "test message", cl.messages[0])
+}
+
+func TestServerRPCWithCallbackToClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	cl := &clientLocal{}
+	cl.messageReceived.Add(1) // Expect one message
+
+	sl := &serverLocal{}
+
+	serverRegistry, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+
+	cl.ForRemotes = clientRegistry.ForRemotes
+	sl.ForRemotes = serverRegistry.ForRemotes
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	iterations := 5
+	err := clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
+		length, err := remote.TestCallback(ctx, int64(iterations))
+		require.NoError(t, err)
+		require.Equal(t, length, int64(iterations))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Wait for message to be received
+	cl.messageReceived.Wait()
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+
+	// Verify results
+	require.Len(t, cl.messages, 1)
+	require.Equal(t, fmt.Sprintf("Incrementing counter by %v", iterations), cl.messages[0])
+}
+
+func TestServerRPCWithCallbackToClientWithError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	cl := &clientLocal{}
+
+	sl := &serverLocal{}
+
+	serverRegistry, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+
+	cl.ForRemotes = clientRegistry.ForRemotes
+	sl.ForRemotes = serverRegistry.ForRemotes
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
+		err := remote.TestCallbackWithError(ctx)
+		// We need to compare strings here since error signatures get stripped when sent over the wire
+		require.Contains(t, err.Error(), errTest.Error())
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestClientRPCWithCallbackToServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	cl := &clientLocal{}
+	sl := &serverLocal{}
+
+	serverRegistry, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+
+	sl.ForRemotes = serverRegistry.ForRemotes
+	cl.ForRemotes = clientRegistry.ForRemotes
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	iterations := 5
+	err := serverRegistry.ForRemotes(func(remoteID string, remote clientRemote) error {
+		length, err := remote.TestCallback(ctx, int64(iterations))
+		require.NoError(t, err)
+		require.Equal(t, length, int64(iterations))
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+
+	// Verify results
+	require.Equal(t, int64(1), sl.counter)
+}
+
+func TestConcurrentRPCCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	cl := &clientLocal{}
+	sl := &serverLocal{}
+
+	serverRegistry, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+
+	cl.ForRemotes = clientRegistry.ForRemotes
+	sl.ForRemotes = serverRegistry.ForRemotes
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	iterations := 5
+	err := serverRegistry.ForRemotes(func(remoteID string, remote clientRemote) error {
+		length, err := remote.TestCallback(ctx, int64(iterations))
+		require.NoError(t, err)
+		require.Equal(t, length, int64(iterations))
+
+		// Concurrent client calling server
+		clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
+			if _, err := remote.TestSimple(ctx, 3); err != nil {
+				return err
+			}
+			return nil
+		})
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+
+	// Verify results
+	require.Equal(t, int64(4), sl.counter)
+}
+
+func TestNestedRPCFromClientToServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	cl := &clientLocal{
+		Nested: &nestedServiceLocal{},
+	}
+	sl := &serverLocal{
+		Nested: &nestedServiceLocal{},
+	}
+
+	_, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+
+	cl.ForRemotes = clientRegistry.ForRemotes
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
+		err := remote.Nested.SetValue(ctx, "test value")
+		require.NoError(t, err)
+
+		value, err := remote.Nested.GetValue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "test value", value)
+
+		err = remote.Nested.SetValue(ctx, "updated value")
+		require.NoError(t, err)
+
+		value, err = remote.Nested.GetValue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "updated value", value)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestBidirectionalNestedRPC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	cl := &clientLocal{
+		Nested: &nestedServiceLocal{
+			value: "initial client value",
+		},
+	}
+	sl := &serverLocal{
+		Nested: &nestedServiceLocal{},
+	}
+
+	serverRegistry, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+
+	cl.ForRemotes = clientRegistry.ForRemotes
+	sl.ForRemotes = serverRegistry.ForRemotes
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	// Test client calling server's nested service
+	err := clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
+		err := remote.Nested.SetValue(ctx, "test server value")
+		require.NoError(t, err)
+
+		value, err := remote.Nested.GetValue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "test server value", value)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Test server calling client's nested service
+	err = serverRegistry.ForRemotes(func(remoteID string, remote clientRemote) error {
+		err := remote.Nested.SetValue(ctx, "test client value")
+		require.NoError(t, err)
+
+		value, err := remote.Nested.GetValue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "test client value", value)
+
+		// Test concurrent access to both nested services
+		var wg sync.WaitGroup
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			value, err := remote.Nested.GetValue(ctx)
+			require.NoError(t, err)
+			require.Equal(t, "test client value", value)
+		}()
+
+		go func() {
+			defer wg.Done()
+			err := clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
+				value, err := remote.Nested.GetValue(ctx)
+				require.NoError(t, err)
+				require.Equal(t, "test server value", value)
+				return nil
+			})
+			require.NoError(t, err)
+		}()
+
+		wg.Wait()
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Test nested service state persistence
+	err = clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
+		value, err := remote.Nested.GetValue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "test server value", value)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = serverRegistry.ForRemotes(func(remoteID string, remote clientRemote) error {
+		value, err := remote.Nested.GetValue(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "test client value", value)
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestRPCWithClosureOnServerFromClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	cl := &closureClientLocal{}
+	sl := &closureServerLocal{}
+
+	_, serverDone := startServer[closureClientRemote, *closureServerLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[closureServerRemote, *closureClientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+
+	cl.ForRemotes = clientRegistry.ForRemotes
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	// Test iterations
+	expectedIterations := 5
+	actualIterations := 0
+
+	if err := clientRegistry.ForRemotes(func(remoteID string, remote closureServerRemote) error {
+		count, err := remote.Iterate(ctx, expectedIterations, func(ctx context.Context, i int, b string) (string, error) {
+			actualIterations++
+
+			return "This is from the caller", nil
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, expectedIterations, count)
+		return nil
+	}); err != nil {
+		require.NoError(t, err)
+	}
+
+	// Cleanup
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+
+	require.Equal(t, expectedIterations, actualIterations)
+}
+
+func TestRPCWithClosureOnClientFromServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	cl := &closureClientLocal{}
+	sl := &closureServerLocal{}
+
+	serverRegistry, serverDone := startServer[closureClientRemote, *closureServerLocal](t, ctx, lis, sl, serverConnected)
+	_, clientDone := startClient[closureServerRemote, *closureClientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+
+	sl.ForRemotes = serverRegistry.ForRemotes
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	// Test iterations
+	expectedIterations := 5
+	actualIterations := 0
+
+	if err := serverRegistry.ForRemotes(func(remoteID string, remote closureClientRemote) error {
+		count, err := remote.Iterate(ctx, expectedIterations, func(ctx context.Context, i int, b string) (string, error) {
+			actualIterations++
+
+			return "This is from the caller", nil
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, expectedIterations, count)
+		return nil
+	}); err != nil {
+		require.NoError(t, err)
+	}
+
+	// Cleanup
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+
+	require.Equal(t, expectedIterations, actualIterations)
+}
+
+func TestRPCsWithReturnValueEdgecases(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	sl := &returnValueLocal{}
+	cl := &returnValueLocal{}
+
+	serverRegistry, serverDone := startServer[returnValueRemote, *returnValueLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[returnValueRemote, *returnValueLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+
+	sl.ForRemotes = serverRegistry.ForRemotes
+	cl.ForRemotes = clientRegistry.ForRemotes
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote returnValueRemote) error {
+		// Test single error return (success case)
+		err := remote.TestSingleError(ctx, false)
+		require.NoError(t, err)
+
+		// Test single error return (error case)
+		err = remote.TestSingleError(ctx, true)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errTest.Error())
+
+		// Test value and error (success case)
+		val, err := remote.TestValueAndError(ctx, false)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), val)
+
+		// Test value and error (error case)
+		val, err = remote.TestValueAndError(ctx, true)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errTest.Error())
+		require.Equal(t, int64(0), val)
+
+		// Test registered error (error case)
+		val, err = remote.TestRegisteredError(ctx)
+		require.ErrorIs(t, err, errRegisteredTest)
+		require.Equal(t, int64(0), val)
+
+		var remoteErr *RemoteError
+		require.ErrorAs(t, err, &remoteErr)
+		require.Equal(t, "registered_test", remoteErr.Code)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestRPCWithComplexClosureArgsAndReturnValues(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *complexClosureServerLocal](t, ctx, lis, &complexClosureServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[complexClosureServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote complexClosureServerRemote) error {
+		res, err := remote.TestComplexClosure(ctx, func(ctx context.Context, event closureEvent, tags map[string]int, note *string) (*closureEventResult, error) {
+			require.Equal(t, closureEvent{Name: "brewed", Count: 2}, event)
+			require.Equal(t, map[string]int{"latte": 1, "mocca": 1}, tags)
+			require.NotNil(t, note)
+			require.Equal(t, "This is from the callee", *note)
+
+			return &closureEventResult{Accepted: true, Reason: "This is from the caller"}, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, closureEventResult{Accepted: true, Reason: "This is from the caller"}, res)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestRPCWithNestedClosures(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *complexClosureServerLocal](t, ctx, lis, &complexClosureServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[complexClosureServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote complexClosureServerRemote) error {
+		calls := []string{}
+
+		res, err := remote.TestNestedClosures(
+			ctx,
+			closureHandlers{
+				Name: "This is from the caller",
+				OnData: func(ctx context.Context, data string) error {
+					calls = append(calls, "data: "+data)
+
+					return nil
+				},
+				OnDone: func(ctx context.Context) error {
+					calls = append(calls, "done")
+
+					return nil
+				},
+				Nested: &closureNestedHandlers{
+					OnDone: func(ctx context.Context) error {
+						calls = append(calls, "nested done")
+
+						return nil
+					},
+				},
+			},
+			[]func(ctx context.Context) error{
+				func(ctx context.Context) error {
+					calls = append(calls, "step 1")
+
+					return nil
+				},
+				func(ctx context.Context) error {
+					calls = append(calls, "step 2")
+
+					return nil
+				},
+			},
+			map[string]func(ctx context.Context, i int) (int, error){
+				"double": func(ctx context.Context, i int) (int, error) {
+					return i * 2, nil
+				},
+			},
+		)
+		require.NoError(t, err)
+		require.Equal(t, 4, res)
+		require.Equal(t, []string{"data: This is from the caller", "done", "nested done", "step 1", "step 2"}, calls)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestRPCWithReturnedClosures(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	sl := &returnedClosureServerLocal{}
+
+	_, serverDone := startServer[struct{}, *returnedClosureServerLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[returnedClosureServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote returnedClosureServerRemote) error {
+		unsubscribe, err := remote.Subscribe(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, unsubscribe)
+		require.Equal(t, int64(1), sl.subscribers.Load())
+
+		subscribers, err := unsubscribe(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), subscribers)
+
+		handlers, err := remote.GetHandlers(ctx, "test")
+		require.NoError(t, err)
+		require.NoError(t, handlers.OnDone(ctx))
+
+		handlers, err = remote.GetHandlers(ctx, "")
+		require.NoError(t, err)
+		require.ErrorContains(t, handlers.OnDone(ctx), "missing name")
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestRPCWithReturnedCallbacks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	sl := &returnedClosureServerLocal{}
+
+	serverRegistry, serverDone := startServer[struct{}, *returnedClosureServerLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[returnedClosureServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote returnedClosureServerRemote) error {
+		unsubscribe, err := remote.SubscribeCallback(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, unsubscribe)
+		require.Equal(t, int64(1), sl.subscribers.Load())
+
+		subscribers, err := unsubscribe.Fn()(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), subscribers)
+
+		require.NoError(t, unsubscribe.Release(ctx))
+
+		_, err = unsubscribe.Fn()(ctx)
+		require.ErrorIs(t, err, ErrClosureDoesNotExist)
+
+		// Released callbacks don't stay registered on the remote
+		serverRegistry.linksLock.Lock()
+		defer serverRegistry.linksLock.Unlock()
+
+		for _, l := range serverRegistry.links {
+			l.closures.closuresLock.Lock()
+			require.Empty(t, l.closures.closures)
+			l.closures.closuresLock.Unlock()
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestCallerCancellationPropagatesToCallee(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	sl := &cancellationServerLocal{
+		callStarted:   make(chan struct{}),
+		callCancelled: make(chan struct{}),
+	}
+
+	_, serverDone := startServer[struct{}, *cancellationServerLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[cancellationServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote cancellationServerRemote) error {
+		callCtx, cancelCallCtx := context.WithCancel(ctx)
+
+		go func() {
+			<-sl.callStarted
+
+			cancelCallCtx()
+		}()
+
+		err := remote.TestBlock(callCtx)
+		require.ErrorIs(t, err, context.Canceled)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-sl.callCancelled:
+	case <-time.After(time.Second * 10):
+		t.Fatal("context of callee was not cancelled")
+	}
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestCallerCancellationPropagatesToClosure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	sl := &cancellationServerLocal{
+		callStarted: make(chan struct{}),
+	}
+
+	_, serverDone := startServer[struct{}, *cancellationServerLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[cancellationServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	closureCancelled := make(chan struct{})
+	err := clientRegistry.ForRemotes(func(remoteID string, remote cancellationServerRemote) error {
+		err := remote.TestBlockingClosure(ctx, func(ctx context.Context) error {
+			close(sl.callStarted)
+
+			<-ctx.Done()
+
+			close(closureCancelled)
+
+			return ctx.Err()
+		})
+		require.Error(t, err)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-closureCancelled:
+	case <-time.After(time.Second * 10):
+		t.Fatal("context of closure was not cancelled")
+	}
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestCallerDeadlinePropagatesToCallee(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	sl := &cancellationServerLocal{
+		deadlineErrs: make(chan error, 1),
+	}
+
+	_, serverDone := startServer[struct{}, *cancellationServerLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[cancellationServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote cancellationServerRemote) error {
+		// Without a deadline, the callee's context shouldn't have one either
+		require.NoError(t, remote.TestWaitForDeadline(ctx))
+		require.NoError(t, <-sl.deadlineErrs)
+
+		// The caller doesn't cancel calls whose deadline was exceeded, so the callee always sees its own deadline being exceeded
+		callCtx, cancelCallCtx := context.WithTimeout(ctx, time.Millisecond*100)
+		defer cancelCallCtx()
+
+		err := remote.TestWaitForDeadline(callCtx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	select {
+	case err := <-sl.deadlineErrs:
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second * 10):
+		t.Fatal("deadline of callee was not exceeded")
+	}
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestCallerDeadlineIsNotCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	var clientConnected sync.WaitGroup
+	clientConnected.Add(1)
+
+	clientRegistry := NewRegistry[cancellationServerRemote, json.RawMessage](struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			clientConnected.Done()
+		},
+	})
+
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- linkConn(ctx, clientRegistry, clientConn, nil)
+	}()
+
+	clientConnected.Wait()
+
+	callErr := make(chan error, 1)
+	go func() {
+		callErr <- clientRegistry.ForRemotes(func(remoteID string, remote cancellationServerRemote) error {
+			callCtx, cancelCallCtx := context.WithTimeout(ctx, time.Millisecond*10)
+			defer cancelCallCtx()
+
+			return remote.TestWaitForDeadline(callCtx)
+		})
+	}()
+
+	requests := make(chan utils.Request[json.RawMessage])
+	go func() {
+		decoder := json.NewDecoder(serverConn)
+		for {
+			var msg Message[json.RawMessage]
+			if err := decoder.Decode(&msg); err != nil {
+				return
+			}
+
+			var req utils.Request[json.RawMessage]
+			if err := json.Unmarshal(*msg.Request, &req); err != nil {
+				return
+			}
+
+			requests <- req
+		}
+	}()
+
+	req := <-requests
+	require.Positive(t, req.Deadline)
+
+	require.ErrorIs(t, <-callErr, context.DeadlineExceeded)
+
+	// The callee's context has the same deadline, so it isn't cancelled as well
+	select {
+	case req := <-requests:
+		t.Fatalf("unexpected request after the deadline was exceeded: %+v", req)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	cancel()
+	<-clientErr
+}
+
+func TestBadCallsReturnErrorsWithoutClosingLink(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *returnValueLocal](t, ctx, lis, &returnValueLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[badCallRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote badCallRemote) error {
+		// Test unknown function
+		err := remote.TestUnknown(ctx)
+		require.ErrorIs(t, err, ErrCannotCallNonFunction)
+
+		// Test invalid argument count
+		err = remote.TestSingleError(ctx, false, "extra")
+		require.ErrorIs(t, err, ErrInvalidArgsCount)
+
+		// Test invalid argument type
+		val, err := remote.TestValueAndError(ctx, "false")
+		require.ErrorIs(t, err, ErrInvalidArg)
+		require.Equal(t, int64(0), val)
+
+		// Test that the link is still usable after bad calls
+		_, err = remote.TestRegisteredError(ctx)
+		require.ErrorIs(t, err, errRegisteredTest)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestPanicsReturnErrorsWithoutClosingLink(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	type panicReport struct {
+		function string
+		value    any
+		stack    []byte
+	}
+	panics := make(chan panicReport, 1)
+
+	_, serverDone := startServerWithHooks[struct{}](t, ctx, lis, &returnValueLocal{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			serverConnected.Done()
+		},
+		OnPanic: func(remoteID, function string, value any, stack []byte) {
+			panics <- panicReport{function, value, stack}
+		},
+	})
+	clientRegistry, clientDone := startClient[returnValueRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote returnValueRemote) error {
+		val, err := remote.TestPanic(ctx)
+		require.ErrorIs(t, err, utils.ErrPanickedWithNonErrorValue)
+		require.Contains(t, err.Error(), "string panic")
+		require.Equal(t, int64(0), val)
+
+		report := <-panics
+		require.Equal(t, "TestPanic", report.function)
+		require.Equal(t, "string panic", report.value)
+		require.Contains(t, string(report.stack), "TestPanic")
+
+		// Test that the link is still usable after a panic
+		val, err = remote.TestValueAndError(ctx, false)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), val)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestServerStreamingRPCs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *streamingServerLocal](t, ctx, lis, &streamingServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[streamingServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote streamingServerRemote) error {
+		ch, err := remote.TestChannel(ctx, 100)
+		require.NoError(t, err)
+
+		values := []int{}
+		for value := range ch {
+			values = append(values, value)
+		}
+		require.Len(t, values, 100)
+		require.Equal(t, 99, values[99])
+
+		seq, err := remote.TestIterator(ctx, 3)
+		require.NoError(t, err)
+
+		values = []int{}
+		seq(func(value int) bool {
+			values = append(values, value)
+
+			return true
+		})
+		require.Equal(t, []int{0, 1, 2}, values)
+
+		seq2, err := remote.TestIteratorWithError(ctx)
+		require.NoError(t, err)
+
+		values = []int{}
+		var streamErr error
+		seq2(func(value int, err error) bool {
+			if err != nil {
+				streamErr = err
+
+				return false
+			}
+
+			values = append(values, value)
+
+			return true
+		})
+		require.Equal(t, []int{0, 1}, values)
+		require.ErrorIs(t, streamErr, errRegisteredTest)
+
+		ch, err = remote.TestStreamError(ctx)
+		require.Error(t, err)
+		require.Equal(t, errTest.Error(), err.Error())
+		require.Nil(t, ch)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestServerStreamingRPCCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	sl := &streamingServerLocal{
+		streamCancelled: make(chan struct{}),
+	}
+
+	_, serverDone := startServer[struct{}, *streamingServerLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[streamingServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote streamingServerRemote) error {
+		callCtx, cancelCallCtx := context.WithCancel(ctx)
+
+		ch, err := remote.TestEndless(callCtx)
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			require.Equal(t, i, <-ch)
+		}
+
+		cancelCallCtx()
+
+		// The channel is closed once the consumer has stopped receiving values
+		for range ch {
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-sl.streamCancelled:
+	case <-time.After(time.Second * 10):
+		t.Fatal("context of stream producer was not cancelled")
+	}
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestStreamingArguments(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *streamingServerLocal](t, ctx, lis, &streamingServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[streamingServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote streamingServerRemote) error {
+		// Send more values than fit into the stream's window so that credits are required
+		in := make(chan int)
+		go func() {
+			defer close(in)
+
+			for i := 1; i <= 200; i++ {
+				in <- i
+			}
+		}()
+
+		sum, err := remote.TestSum(ctx, in)
+		require.NoError(t, err)
+		require.Equal(t, 20100, sum)
+
+		sum, err = remote.TestSumIterator(ctx, func(yield func(int) bool) {
+			for i := 1; i <= 3; i++ {
+				if !yield(i) {
+					return
+				}
+			}
+		})
+		require.NoError(t, err)
+		require.Equal(t, 6, sum)
+
+		out := make(chan int)
+		values := []int{}
+		received := make(chan struct{})
+		go func() {
+			defer close(received)
+
+			for value := range out {
+				values = append(values, value)
+			}
+		}()
+
+		require.NoError(t, remote.TestCount(ctx, 200, out))
+
+		<-received
+		require.Len(t, values, 200)
+		require.Equal(t, 199, values[199])
+
+		in = make(chan int)
+		out = make(chan int)
+		go func() {
+			defer close(in)
+
+			for i := 0; i < 3; i++ {
+				in <- i
+			}
+		}()
+
+		values = []int{}
+		received = make(chan struct{})
+		go func() {
+			defer close(received)
+
+			for value := range out {
+				values = append(values, value)
+			}
+		}()
+
+		require.NoError(t, remote.TestDouble(ctx, in, out))
+
+		<-received
+		require.Equal(t, []int{0, 2, 4}, values)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestIncomingStreamWindowExceeded(t *testing.T) {
+	messages := []*utils.Stream[json.RawMessage]{}
+	stream := newIncomingStream[json.RawMessage]("1", func(msg *utils.Stream[json.RawMessage]) error {
+		messages = append(messages, msg)
+
+		return nil
+	})
+
+	// A producer that ignores the flow control can't grow the queue beyond the stream's window
+	for i := 0; i < streamWindowLen+1; i++ {
+		stream.push(json.RawMessage(strconv.Itoa(i)))
+	}
+
+	require.Len(t, messages, 1)
+	require.True(t, messages[0].Cancel)
+
+	for i := 0; i < streamWindowLen; i++ {
+		value, ok, err := stream.next(context.Background())
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, json.RawMessage(strconv.Itoa(i)), value)
+	}
+
+	_, ok, err := stream.next(context.Background())
+	require.False(t, ok)
+	require.ErrorIs(t, err, ErrStreamWindowExceeded)
+
+	// Credit that was granted can be used by the producer
+	stream = newIncomingStream[json.RawMessage]("2", func(msg *utils.Stream[json.RawMessage]) error {
+		return nil
+	})
+
+	for i := 0; i < streamWindowLen*2; i++ {
+		stream.push(json.RawMessage(strconv.Itoa(i)))
+
+		_, ok, err := stream.next(context.Background())
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+}
+
+func TestStreamingArgumentsOfFailedCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *streamingServerLocal](t, ctx, lis, &streamingServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[streamingInvalidServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote streamingInvalidServerRemote) error {
+		// Streams that the remote never registered are ended once the call has failed
+		out := make(chan int)
+		received := make(chan struct{})
+		go func() {
+			defer close(received)
+
+			for range out {
+			}
+		}()
+
+		require.ErrorIs(t, remote.TestMissing(ctx, out), ErrCannotCallNonFunction)
+		<-received
+
+		out = make(chan int)
+		received = make(chan struct{})
+		go func() {
+			defer close(received)
+
+			for range out {
+			}
+		}()
+
+		require.ErrorIs(t, remote.TestCount(ctx, "200", out), ErrInvalidArg)
+		<-received
+
+		stopped := make(chan struct{})
+		_, err := remote.TestSumIterator(ctx, func(yield func(int) bool) {
+			defer close(stopped)
+
+			for i := 0; ; i++ {
+				if !yield(i) {
+					return
+				}
+			}
+		}, 1)
+		require.ErrorIs(t, err, ErrInvalidArgsCount)
+		<-stopped
+
+		clientRegistry.linksLock.Lock()
+		l := clientRegistry.links[remoteID]
+		clientRegistry.linksLock.Unlock()
+
+		require.Eventually(t, func() bool {
+			l.streams.lock.Lock()
+			defer l.streams.lock.Unlock()
+
+			return len(l.streams.incoming) == 0 && len(l.streams.outgoing) == 0
+		}, time.Second, time.Millisecond*10)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestStreamingArgumentsFlowControl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	sl := &streamingServerLocal{
+		slowConsumerStarted: make(chan struct{}),
+		slowConsumerRelease: make(chan struct{}),
+	}
+
+	_, serverDone := startServer[struct{}, *streamingServerLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[streamingServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote streamingServerRemote) error {
+		var (
+			in   = make(chan int)
+			sent atomic.Int64
+		)
+		go func() {
+			for i := 0; ; i++ {
+				select {
+				case in <- i:
+					sent.Add(1)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		go func() {
+			<-sl.slowConsumerStarted
+
+			// Give the producer time to send more values than the consumer is ready to receive
+			time.Sleep(time.Millisecond * 100)
+
+			require.LessOrEqual(t, sent.Load(), int64(streamWindowLen+2))
+
+			close(sl.slowConsumerRelease)
+		}()
+
+		require.NoError(t, remote.TestSlowConsumer(ctx, in))
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestPersistentCallbacks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *callbackServerLocal](t, ctx, lis, &callbackServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[callbackServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote callbackServerRemote) error {
+		values := make(chan int, 2)
+		require.NoError(t, remote.Subscribe(ctx, NewCallback(func(ctx context.Context, value int) error {
+			values <- value
+
+			return nil
+		})))
+
+		// The callback can still be called after `Subscribe` has returned
+		require.NoError(t, remote.Publish(ctx, 1))
+		require.NoError(t, remote.Publish(ctx, 2))
+		require.Equal(t, 1, <-values)
+		require.Equal(t, 2, <-values)
+
+		require.NoError(t, remote.Unsubscribe(ctx))
+
+		require.ErrorIs(t, remote.Publish(ctx, 3), ErrClosureDoesNotExist)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestRemoteObjectReferences(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *referenceServerLocal](t, ctx, lis, &referenceServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[referenceServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote referenceServerRemote) error {
+		lobby, err := remote.OpenRoom(ctx, "lobby")
+		require.NoError(t, err)
+
+		kitchen, err := remote.OpenRoom(ctx, "kitchen")
+		require.NoError(t, err)
+
+		// Each reference calls the methods of its own object
+		require.NoError(t, lobby.Object().SendMessage(ctx, "Hello"))
+		require.NoError(t, kitchen.Object().SendMessage(ctx, "Hi"))
+
+		messages, err := lobby.Object().GetMessages(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"lobby: Hello"}, messages)
+
+		require.NoError(t, kitchen.Object().Moderation.Clear(ctx))
+
+		messages, err = kitchen.Object().GetMessages(ctx)
+		require.NoError(t, err)
+		require.Empty(t, messages)
+
+		require.NoError(t, lobby.Release(ctx))
+
+		require.ErrorIs(t, lobby.Object().SendMessage(ctx, "Hello again"), ErrReferenceDoesNotExist)
+		require.NoError(t, kitchen.Object().SendMessage(ctx, "Still here"))
+
+		// Releasing a reference again is a no-op
+		require.NoError(t, lobby.Release(ctx))
+
+		none, err := remote.OpenRoom(ctx, "")
+		require.NoError(t, err)
+		require.Nil(t, none)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestPipelinedCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	sl := &pipelineServerLocal{
+		opened: make(chan struct{}),
+	}
+
+	_, serverDone := startServer[struct{}, *pipelineServerLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[pipelineServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote pipelineServerRemote) error {
+		// Pipelined calls return before the remote has returned
+		db, err := remote.OpenDatabase(ctx, "test")
+		require.NoError(t, err)
+
+		users, err := db.Object().Table(ctx, "users")
+		require.NoError(t, err)
+
+		missing, err := db.Object().Table(ctx, "missing")
+		require.NoError(t, err)
+
+		invalid, err := remote.OpenDatabase(ctx, "")
+		require.NoError(t, err)
+
+		invalidUsers, err := invalid.Object().Table(ctx, "users")
+		require.NoError(t, err)
+
+		close(sl.opened)
+
+		name, err := users.Object().Get(ctx, "1")
+		require.NoError(t, err)
+		require.Equal(t, "Alice", name)
+
+		// Errors of pipelined calls are returned by the calls that target their results
+		_, err = missing.Object().Get(ctx, "1")
+		require.ErrorContains(t, err, "table does not exist")
+
+		_, err = invalidUsers.Object().Get(ctx, "1")
+		require.ErrorContains(t, err, "missing name")
+
+		require.ErrorContains(t, invalid.Release(ctx), "missing name")
+
+		require.NoError(t, users.Release(ctx))
+		require.NoError(t, db.Release(ctx))
+
+		_, err = users.Object().Get(ctx, "1")
+		require.ErrorIs(t, err, ErrReferenceDoesNotExist)
+
+		released, err := db.Object().Table(ctx, "users")
+		require.NoError(t, err)
+
+		_, err = released.Object().Get(ctx, "1")
+		require.ErrorIs(t, err, ErrReferenceDoesNotExist)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestPipelinedCallsWithStreams(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	sl := &pipelineServerLocal{
+		opened: make(chan struct{}),
+	}
+
+	_, serverDone := startServer[struct{}, *pipelineServerLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[pipelineServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote pipelineServerRemote) error {
+		db, err := remote.OpenDatabase(ctx, "test")
+		require.NoError(t, err)
+
+		// The streams are only read once the requests have been sent
+		sumIn := make(chan int)
+		sumErr := make(chan error)
+		go func() {
+			_, err := db.Object().Sum(ctx, sumIn)
+
+			sumErr <- err
+		}()
+		sumIn <- 1
+
+		doubleIn := make(chan int)
+		doubleOut := make(chan int)
+		doubleErr := make(chan error)
+		go func() {
+			doubleErr <- db.Object().Double(ctx, doubleIn, doubleOut)
+		}()
+		doubleIn <- 1
+
+		// Requests are handled in order, so the calls are queued once a later call has returned
+		require.NoError(t, remote.Ping(ctx))
+
+		close(sl.opened)
+
+		go func() {
+			defer close(sumIn)
+
+			for i := 2; i <= 3; i++ {
+				sumIn <- i
+			}
+		}()
+		require.ErrorIs(t, <-sumErr, ErrStreamToQueuedCall)
+
+		close(doubleIn)
+		for range doubleOut {
+		}
+		require.ErrorIs(t, <-doubleErr, ErrStreamToQueuedCall)
+
+		// Calls that target the result of a pipelined call that has already returned can take streams
+		in := make(chan int)
+		go func() {
+			defer close(in)
+
+			for i := 1; i <= 3; i++ {
+				in <- i
+			}
+		}()
+
+		sum, err := db.Object().Sum(ctx, in)
+		require.NoError(t, err)
+		require.Equal(t, 6, sum)
+
+		in = make(chan int)
+		out := make(chan int)
+		go func() {
+			defer close(in)
+
+			for i := 1; i <= 3; i++ {
+				in <- i
+			}
+		}()
+
+		values := []int{}
+		received := make(chan struct{})
+		go func() {
+			defer close(received)
+
+			for value := range out {
+				values = append(values, value)
+			}
+		}()
+
+		require.NoError(t, db.Object().Double(ctx, in, out))
+
+		<-received
+		require.Equal(t, []int{2, 4, 6}, values)
+
+		require.NoError(t, db.Release(ctx))
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestNotifications(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	sl := &notificationServerLocal{
+		messages: make(chan string),
+	}
+
+	_, serverDone := startServer[struct{}, *notificationServerLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[notificationServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote notificationServerRemote) error {
+		// Notifications return before the remote has received them
+		require.NoError(t, remote.Log(ctx, "Hello"))
+		require.NoError(t, remote.Log(ctx, "World"))
+
+		received := []string{<-sl.messages, <-sl.messages}
+		require.ElementsMatch(t, []string{"Hello", "World"}, received)
+
+		// Errors of notifications are discarded
+		require.NoError(t, remote.Fail(ctx))
+
+		pong, err := remote.Ping(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "pong", pong)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestBatchCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	sl := &batchServerLocal{}
+	sl.rendezvous.Add(2)
+
+	_, serverDone := startServer[struct{}, *batchServerLocal](t, ctx, lis, sl, serverConnected)
+	clientRegistry, clientDone := startClient[struct{}, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote struct{}) error {
+		var (
+			doubled int
+			applied int
+		)
+		errs, err := clientRegistry.BatchCall(
+			ctx,
+			remoteID,
+			BatchCall{Function: "Rendezvous"},
+			BatchCall{Function: "Double", Args: []any{21}, Result: &doubled},
+			BatchCall{Function: "Fail"},
+			BatchCall{Function: "Missing"},
+			BatchCall{Function: "Apply", Args: []any{2, func(ctx context.Context, i int) (int, error) {
+				return i + 1, nil
+			}}, Result: &applied},
+			BatchCall{Function: "Stream"},
+			BatchCall{Function: "Rendezvous"},
+		)
+		require.NoError(t, err)
+		require.Len(t, errs, 7)
+
+		require.NoError(t, errs[0])
+		require.NoError(t, errs[1])
+		require.Equal(t, 42, doubled)
+		require.ErrorContains(t, errs[2], "batch call failed")
+		require.ErrorIs(t, errs[3], ErrCannotCallNonFunction)
+		require.NoError(t, errs[4])
+		require.Equal(t, 3, applied)
+		require.ErrorIs(t, errs[5], ErrStreamInBatch)
+		require.NoError(t, errs[6])
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = clientRegistry.BatchCall(ctx, "missing", BatchCall{Function: "Double", Args: []any{1}})
+	require.ErrorIs(t, err, ErrRemoteDoesNotExist)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestBatchCallsWithInterceptors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *batchServerLocal](t, ctx, lis, &batchServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClientWithHooks[struct{}](t, ctx, lis.Addr().String(), struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			clientConnected.Done()
+		},
+
+		ClientInterceptors: []Interceptor{
+			func(ctx context.Context, call *CallInfo, next Invoker) ([]any, error) {
+				return next(WithOutgoingMetadata(ctx, Metadata{"token": "secret"}), call)
+			},
+			func(ctx context.Context, call *CallInfo, next Invoker) ([]any, error) {
+				switch call.Function {
+				case "Cached":
+					return []any{1}, nil
+
+				case "Double":
+					call.Args[0] = call.Args[0].(int) + 1
+
+				case "Fail":
+					if _, err := next(ctx, call); err == nil {
+						return nil, errors.New("call should have failed")
+					}
+				}
+
+				return next(ctx, call)
+			},
+		},
+	})
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote struct{}) error {
+		var (
+			token   string
+			cached  int
+			doubled int
+		)
+		errs, err := clientRegistry.BatchCall(
+			ctx,
+			remoteID,
+			BatchCall{Function: "Token", Result: &token},
+			BatchCall{Function: "Cached", Result: &cached},
+			BatchCall{Function: "Double", Args: []any{20}, Result: &doubled},
+			BatchCall{Function: "Fail"},
+		)
+		require.NoError(t, err)
+		require.Len(t, errs, 4)
+
+		// Interceptors can change the context and arguments of the calls in a batch or short-circuit them
+		require.NoError(t, errs[0])
+		require.Equal(t, "secret", token)
+		require.NoError(t, errs[1])
+		require.Equal(t, 1, cached)
+		require.NoError(t, errs[2])
+		require.Equal(t, 42, doubled)
+		require.ErrorIs(t, errs[3], ErrBatchCallRepeated)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestVariadicArgsAndMultipleReturnValues(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *variadicServerLocal](t, ctx, lis, &variadicServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[variadicServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote variadicServerRemote) error {
+		joined, err := remote.Join(ctx, ", ", "a", "b", "c")
+		require.NoError(t, err)
+		require.Equal(t, "a, b, c", joined)
+
+		joined, err = remote.Join(ctx, ", ")
+		require.NoError(t, err)
+		require.Equal(t, "", joined)
+
+		joined, err = remote.Join(ctx, "-", []string{"x", "y"}...)
+		require.NoError(t, err)
+		require.Equal(t, "x-y", joined)
+
+		quotient, remainder, err := remote.Divide(ctx, 7, 2)
+		require.NoError(t, err)
+		require.Equal(t, 3, quotient)
+		require.Equal(t, 1, remainder)
+
+		quotient, remainder, err = remote.Divide(ctx, 7, 0)
+		require.ErrorContains(t, err, "division by zero")
+		require.Equal(t, 0, quotient)
+		require.Equal(t, 0, remainder)
+
+		prefix, sum, err := remote.Apply(ctx, func(ctx context.Context, prefix string, values ...int) (string, int, error) {
+			sum := 0
+			for _, value := range values {
+				sum += value
+			}
+
+			return prefix, sum, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "sum", prefix)
+		require.Equal(t, 6, sum)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestFunctionsWithoutReturnValues(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *variadicServerLocal](t, ctx, lis, &variadicServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[variadicServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote variadicServerRemote) error {
+		require.NoError(t, remote.Ping(ctx))
+
+		// Test that the link is still usable after calling a function without return values
+		joined, err := remote.Join(ctx, ", ", "a", "b")
+		require.NoError(t, err)
+		require.Equal(t, "a, b", joined)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestDynamicCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *variadicServerLocal](t, ctx, lis, &variadicServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[struct{}, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, _ struct{}) error {
+		remote, err := clientRegistry.GetRemote(remoteID)
+		require.NoError(t, err)
+		require.Equal(t, remoteID, remote.ID())
+
+		var joined string
+		require.NoError(t, remote.Call(ctx, "Join", []any{", ", "a", "b", "c"}, &joined))
+		require.Equal(t, "a, b, c", joined)
+
+		// Results can be discarded
+		require.NoError(t, remote.Call(ctx, "Join", []any{", "}, nil))
+
+		var divided []int
+		require.NoError(t, remote.Call(ctx, "Divide", []any{7, 2}, &divided))
+		require.Equal(t, []int{3, 1}, divided)
+
+		require.ErrorContains(t, remote.Call(ctx, "Divide", []any{7, 0}, &divided), "division by zero")
+
+		var applied []any
+		require.NoError(t, remote.Call(ctx, "Apply", []any{func(ctx context.Context, prefix string, values ...int) (string, int, error) {
+			return prefix, len(values), nil
+		}}, &applied))
+		require.Equal(t, []any{"sum", float64(3)}, applied)
+
+		require.ErrorIs(t, remote.Call(ctx, "Missing", nil, nil), ErrCannotCallNonFunction)
+
+		require.ErrorIs(t, remote.Call(ctx, "Join", []any{", "}, joined), ErrInvalidReturn)
+
+		// Return values that don't match the result only fail the call, not the entire link
+		var mismatched int
+		require.ErrorIs(t, remote.Call(ctx, "Join", []any{", ", "a"}, &mismatched), ErrInvalidReturn)
+
+		require.NoError(t, remote.Call(ctx, "Join", []any{", ", "a", "b"}, &joined))
+		require.Equal(t, "a, b", joined)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = clientRegistry.GetRemote("missing")
+	require.ErrorIs(t, err, ErrRemoteDoesNotExist)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestRegisterAndUnregisterServices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	serverRegistry, serverDone := startServer[struct{}, *variadicServerLocal](t, ctx, lis, &variadicServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[struct{}, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	require.ErrorIs(t, serverRegistry.Register("", &nestedServiceLocal{}), ErrInvalidFunctionCallPath)
+	require.ErrorIs(t, serverRegistry.Register("Plugins.Invalid", 1), ErrInvalidService)
+	require.ErrorIs(t, serverRegistry.Unregister("Plugins.Foo"), ErrServiceNotRegistered)
+
+	err := clientRegistry.ForRemotes(func(remoteID string, _ struct{}) error {
+		remote, err := clientRegistry.GetRemote(remoteID)
+		require.NoError(t, err)
+
+		var value string
+		require.ErrorIs(t, remote.Call(ctx, "Plugins.Foo.GetValue", nil, &value), ErrCannotCallNonFunction)
+
+		// Services are available to links that are already connected
+		require.NoError(t, serverRegistry.Register("Plugins.Foo", &nestedServiceLocal{value: "foo"}))
+		require.ErrorIs(t, serverRegistry.Register("Plugins.Foo", &nestedServiceLocal{}), ErrServiceAlreadyRegistered)
+
+		require.NoError(t, remote.Call(ctx, "Plugins.Foo.GetValue", nil, &value))
+		require.Equal(t, "foo", value)
+
+		require.NoError(t, serverRegistry.Register("Plugins.Foo.Nested", &nestedServiceLocal{value: "nested"}))
+
+		require.NoError(t, remote.Call(ctx, "Plugins.Foo.Nested.GetValue", nil, &value))
+		require.Equal(t, "nested", value)
+
+		require.NoError(t, serverRegistry.Register("Plugins.Bar", func(ctx context.Context, i int) (int, error) {
+			return i * 2, nil
+		}))
+
+		var doubled int
+		require.NoError(t, remote.Call(ctx, "Plugins.Bar", []any{21}, &doubled))
+		require.Equal(t, 42, doubled)
+
+		// Services take precedence over local RPCs
+		require.NoError(t, serverRegistry.Register("Join", func(ctx context.Context, separator string, parts ...string) (string, error) {
+			return "overridden", nil
+		}))
+
+		var joined string
+		require.NoError(t, remote.Call(ctx, "Join", []any{", ", "a"}, &joined))
+		require.Equal(t, "overridden", joined)
+
+		require.NoError(t, serverRegistry.Unregister("Join"))
+
+		require.NoError(t, remote.Call(ctx, "Join", []any{", ", "a", "b"}, &joined))
+		require.Equal(t, "a, b", joined)
+
+		require.NoError(t, serverRegistry.Unregister("Plugins.Foo"))
+
+		require.ErrorIs(t, remote.Call(ctx, "Plugins.Foo.GetValue", nil, &value), ErrCannotCallNonFunction)
+
+		require.NoError(t, remote.Call(ctx, "Plugins.Foo.Nested.GetValue", nil, &value))
+		require.Equal(t, "nested", value)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+func TestLocalFactory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	var (
+		closed   = make(chan string, 1)
+		sessions = make(chan *sessionServerLocal, 1)
+	)
+	_, serverDone := startServer[struct{}](t, ctx, lis, func(remoteID string) any {
+		session := &sessionServerLocal{
+			remoteID: remoteID,
+			closed:   closed,
+		}
+
+		sessions <- session
+
+		return session
+	}, serverConnected)
+	clientRegistry, clientDone := startClient[sessionServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	session := <-sessions
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote sessionServerRemote) error {
+		for i := 1; i <= 3; i++ {
+			counter, err := remote.Increment(ctx)
+			require.NoError(t, err)
+			require.Equal(t, i, counter)
+		}
+
+		sessionRemoteID, err := remote.GetRemoteID(ctx)
+		require.NoError(t, err)
+		require.Equal(t, session.remoteID, sessionRemoteID)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+
+	// The local RPCs are closed once the link has been closed
+	require.Equal(t, session.remoteID, <-closed)
+}
+
+func TestLocalFactoryWaitsForInFlightCalls(t *testing.T) {
+	serverCtx, cancelServer := context.WithCancel(context.Background())
+	defer cancelServer()
+
+	clientCtx, cancelClient := context.WithCancel(context.Background())
+	defer cancelClient()
+
+	sl := &inFlightServerLocal{
+		started: make(chan struct{}),
+		closed:  make(chan bool, 1),
+	}
+
+	serverRegistry := NewRegistry[struct{}, json.RawMessage](func(remoteID string) any {
+		return sl
+	}, nil)
+
+	var clientConnected sync.WaitGroup
+	clientConnected.Add(1)
+
+	clientRegistry := NewRegistry[inFlightServerRemote, json.RawMessage](struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			clientConnected.Done()
+		},
+	})
+
+	serverConn, clientConn := net.Pipe()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- linkConn(serverCtx, serverRegistry, serverConn, nil)
+	}()
+
+	go func() {
+		_ = linkConn(clientCtx, clientRegistry, clientConn, nil)
+	}()
+
+	clientConnected.Wait()
+
+	var remote inFlightServerRemote
+	require.NoError(t, clientRegistry.ForRemotes(func(remoteID string, r inFlightServerRemote) error {
+		remote = r
+
+		return nil
+	}))
+
+	go func() {
+		_ = remote.Wait(clientCtx)
+	}()
+
+	<-sl.started
+
+	// The server's link is closed by the client disconnecting while the call is still in flight
+	cancelClient()
+	require.Error(t, <-serverErr)
+
+	// The in-flight call is cancelled, and the local RPCs are only closed once it has returned
+	require.True(t, <-sl.closed)
+}
+
+func TestInterceptors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	var (
+		serverCalls     []string
+		serverCallsLock sync.Mutex
+	)
+	_, serverDone := startServerWithHooks[struct{}](t, ctx, lis, &variadicServerLocal{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			serverConnected.Done()
+		},
+
+		ServerInterceptors: []Interceptor{
+			func(ctx context.Context, call *CallInfo, next Invoker) ([]any, error) {
+				require.NotEmpty(t, call.RemoteID)
+				require.Equal(t, call.RemoteID, GetRemoteID(ctx))
+
+				serverCallsLock.Lock()
+				serverCalls = append(serverCalls, call.Function)
+				serverCallsLock.Unlock()
+
+				return next(ctx, call)
+			},
+			func(ctx context.Context, call *CallInfo, next Invoker) ([]any, error) {
+				if call.Function == "Join" {
+					switch call.Args[0] {
+					case "!":
+						return []any{"short-circuited"}, nil
+					case "?":
+						return []any{}, nil
+					}
+				}
+
+				return next(ctx, call)
+			},
+		},
+	})
+
+	var clientResults []any
+	clientRegistry, clientDone := startClientWithHooks[variadicServerRemote](t, ctx, lis.Addr().String(), struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			clientConnected.Done()
+		},
+
+		ClientInterceptors: []Interceptor{
+			func(ctx context.Context, call *CallInfo, next Invoker) ([]any, error) {
+				results, err := next(ctx, call)
+
+				clientResults = append(clientResults, results...)
+
+				return results, err
+			},
+			func(ctx context.Context, call *CallInfo, next Invoker) ([]any, error) {
+				results, err := next(ctx, call)
+				if err != nil && call.Function == "Divide" {
+					// Retry with a valid divisor
+					call.Args[1] = 1
+
+					return next(ctx, call)
+				}
+
+				return results, err
+			},
+		},
+	})
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote variadicServerRemote) error {
+		joined, err := remote.Join(ctx, "!", "a", "b")
+		require.NoError(t, err)
+		require.Equal(t, "short-circuited", joined)
+
+		joined, err = remote.Join(ctx, ", ", "a", "b")
+		require.NoError(t, err)
+		require.Equal(t, "a, b", joined)
+
+		_, err = remote.Join(ctx, "?")
+		require.ErrorContains(t, err, ErrInvalidReturn.Error())
+
+		quotient, remainder, err := remote.Divide(ctx, 7, 0)
+		require.NoError(t, err)
+		require.Equal(t, 7, quotient)
+		require.Equal(t, 0, remainder)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+
+	require.Equal(t, []string{"Join", "Join", "Join", "Divide", "Divide"}, serverCalls)
+	require.Equal(t, []any{"short-circuited", "a, b", "", 7, 0}, clientResults)
+}
+
+func TestMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lis, serverConnected, clientConnected := setupConnection(t)
+	defer lis.Close()
+
+	_, serverDone := startServer[struct{}, *metadataServerLocal](t, ctx, lis, &metadataServerLocal{}, serverConnected)
+	clientRegistry, clientDone := startClient[metadataServerRemote, struct{}](t, ctx, lis.Addr().String(), struct{}{}, clientConnected)
+
+	// Wait for client to connect to server
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote metadataServerRemote) error {
+		value, err := remote.Echo(ctx, "trace-id")
+		require.NoError(t, err)
+		require.Equal(t, "", value)
+
+		var responseMetadata []Metadata
+		callCtx := WithResponseMetadataHandler(
+			WithOutgoingMetadata(
+				WithOutgoingMetadata(ctx, Metadata{"trace-id": "1", "locale": "en"}),
+				Metadata{"trace-id": "2"},
+			),
+			func(md Metadata) {
+				responseMetadata = append(responseMetadata, md)
+			},
+		)
+
+		value, err = remote.Echo(callCtx, "trace-id")
+		require.NoError(t, err)
+		require.Equal(t, "2", value)
+
+		value, err = remote.Echo(callCtx, "locale")
+		require.NoError(t, err)
+		require.Equal(t, "en", value)
+
+		var batchValue string
+		errs, err := clientRegistry.BatchCall(callCtx, remoteID, BatchCall{Function: "Echo", Args: []any{"locale"}, Result: &batchValue})
+		require.NoError(t, err)
+		require.NoError(t, errs[0])
+		require.Equal(t, "en", batchValue)
+
+		require.Equal(t, []Metadata{{"echoed": "trace-id"}, {"echoed": "locale"}, {"echoed": "locale"}}, responseMetadata)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	clientDone.Wait()
+	serverDone.Wait()
+}
+
+// linkConn links the registry over `conn` and closes it once the link has been closed
+func linkConn[R any](ctx context.Context, registry *Registry[R, json.RawMessage], conn net.Conn, hooks *LinkHooks) error {
+	defer conn.Close()
+
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+
+	return registry.LinkStream(
+		ctx,
+
+		func(v Message[json.RawMessage]) error {
+			return encoder.Encode(v)
+		},
+		func(v *Message[json.RawMessage]) error {
+			return decoder.Decode(v)
+		},
+
+		func(v any) (json.RawMessage, error) {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			return json.RawMessage(b), nil
+		},
+		func(data json.RawMessage, v any) error {
+			return json.Unmarshal([]byte(data), v)
+		},
+
+		hooks,
+	)
+}
+
+// linkConns links the server and client registries over a TCP connection and returns the errors returned by their links
+func linkConns[SR, CR any](
+	t *testing.T,
+	ctx context.Context,
+
+	serverRegistry *Registry[SR, json.RawMessage],
+	clientRegistry *Registry[CR, json.RawMessage],
+	clientHooks *LinkHooks,
+) (serverErr chan error, clientErr chan error) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	serverErr, clientErr = make(chan error, 1), make(chan error, 1)
+
+	go func() {
+		defer lis.Close()
+
+		conn, err := lis.Accept()
+		if err != nil {
+			serverErr <- err
+
+			return
+		}
+
+		serverErr <- linkConn(ctx, serverRegistry, conn, nil)
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	require.NoError(t, err)
+
+	go func() {
+		clientErr <- linkConn(ctx, clientRegistry, conn, clientHooks)
+	}()
+
+	return serverErr, clientErr
+}
+
+func TestHandshakeAcceptsCredentials(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var serverConnected sync.WaitGroup
+	serverConnected.Add(1)
+
+	serverRegistry := NewRegistry[struct{}, json.RawMessage](&principalServerLocal{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			serverConnected.Done()
+		},
+
+		// The server sends credentials, too, even though the client doesn't verify them
+		Credentials: Metadata{"token": "server"},
+		VerifyCredentials: func(remoteID string, credentials Metadata) (any, error) {
+			require.NotEmpty(t, remoteID)
+
+			if credentials["token"] != "secret" {
+				return nil, errors.New("invalid token")
+			}
+
+			return "alice", nil
+		},
+	})
+
+	var clientConnected sync.WaitGroup
+	clientConnected.Add(1)
+
+	clientRegistry := NewRegistry[principalServerRemote, json.RawMessage](struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			clientConnected.Done()
+		},
+	})
+
+	serverErr, clientErr := linkConns(t, ctx, serverRegistry, clientRegistry, &LinkHooks{
+		Credentials: Metadata{"token": "secret"},
+	})
+
+	serverConnected.Wait()
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote principalServerRemote) error {
+		principal, err := remote.GetPrincipal(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "alice", principal)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	cancel()
+	require.ErrorIs(t, <-clientErr, context.Canceled)
+	<-serverErr
+}
+
+func TestHandshakeRejectsCredentials(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverRegistry := NewRegistry[struct{}, json.RawMessage](&principalServerLocal{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			require.Fail(t, "rejected remote was connected")
+		},
+
+		VerifyCredentials: func(remoteID string, credentials Metadata) (any, error) {
+			if credentials["token"] != "secret" {
+				return nil, errors.New("invalid token")
+			}
+
+			return "alice", nil
+		},
+	})
+
+	clientRegistry := NewRegistry[principalServerRemote, json.RawMessage](struct{}{}, &RegistryHooks{
+		Credentials: Metadata{"token": "wrong"},
+	})
+
+	serverErr, clientErr := linkConns(t, ctx, serverRegistry, clientRegistry, nil)
+
+	err := <-clientErr
+	require.ErrorIs(t, err, ErrLinkRejected)
+	require.ErrorContains(t, err, "invalid token")
+
+	err = <-serverErr
+	require.ErrorIs(t, err, ErrLinkRejected)
+	require.ErrorContains(t, err, "invalid token")
+}
+
+func TestHandshakeWithoutCredentials(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverRegistry := NewRegistry[struct{}, json.RawMessage](&principalServerLocal{}, &RegistryHooks{
+		VerifyCredentials: func(remoteID string, credentials Metadata) (any, error) {
+			require.Nil(t, credentials)
+
+			return "anonymous", nil
+		},
+	})
+
+	var clientConnected sync.WaitGroup
+	clientConnected.Add(1)
+
+	clientRegistry := NewRegistry[principalServerRemote, json.RawMessage](struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			clientConnected.Done()
+		},
+	})
 
-	cl.ForRemotes = clientRegistry.ForRemotes
-	sl.ForRemotes = serverRegistry.ForRemotes
+	serverErr, clientErr := linkConns(t, ctx, serverRegistry, clientRegistry, nil)
 
-	// Wait for client to connect to server
-	serverConnected.Wait()
 	clientConnected.Wait()
 
-	err := clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
-		err := remote.TestCallbackWithError(ctx)
-		// We need to compare strings here since error signatures get stripped when sent over the wire
-		require.Contains(t, err.Error(), errTest.Error())
+	// The remote is verified with its first call if it doesn't send credentials
+	err := clientRegistry.ForRemotes(func(remoteID string, remote principalServerRemote) error {
+		principal, err := remote.GetPrincipal(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "anonymous", principal)
 
 		return nil
 	})
 	require.NoError(t, err)
 
 	cancel()
-	clientDone.Wait()
-	serverDone.Wait()
+	<-clientErr
+	<-serverErr
 }
 
-func TestClientRPCWithCallbackToServer(t *testing.T) {
+func TestHandshakeWithEmptyCredentials(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	lis, serverConnected, clientConnected := setupConnection(t)
-	defer lis.Close()
+	var serverConnected sync.WaitGroup
+	serverConnected.Add(1)
 
-	cl := &clientLocal{}
-	sl := &serverLocal{}
+	principals := make(chan any, 1)
+	serverRegistry := NewRegistry[principalServerRemote, json.RawMessage](struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			serverConnected.Done()
+		},
 
-	serverRegistry, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, sl, serverConnected)
-	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+		VerifyCredentials: func(remoteID string, credentials Metadata) (any, error) {
+			require.Empty(t, credentials)
 
-	sl.ForRemotes = serverRegistry.ForRemotes
-	cl.ForRemotes = clientRegistry.ForRemotes
+			principals <- "anonymous"
 
-	// Wait for client to connect to server
+			return "anonymous", nil
+		},
+	})
+
+	clientRegistry := NewRegistry[struct{}, json.RawMessage](&principalServerLocal{}, &RegistryHooks{
+		Credentials: Metadata{},
+	})
+
+	serverErr, clientErr := linkConns(t, ctx, serverRegistry, clientRegistry, nil)
+
+	// Remotes with empty credentials are verified even if they never call an RPC, so that the verifier can call their RPCs
 	serverConnected.Wait()
-	clientConnected.Wait()
+	require.Equal(t, "anonymous", <-principals)
 
-	iterations := 5
-	err := serverRegistry.ForRemotes(func(remoteID string, remote clientRemote) error {
-		length, err := remote.TestCallback(ctx, int64(iterations))
+	err := serverRegistry.ForRemotes(func(remoteID string, remote principalServerRemote) error {
+		principal, err := remote.GetPrincipal(ctx)
 		require.NoError(t, err)
-		require.Equal(t, length, int64(iterations))
+		require.Equal(t, "", principal)
+
 		return nil
 	})
 	require.NoError(t, err)
 
 	cancel()
-	clientDone.Wait()
-	serverDone.Wait()
-
-	// Verify results
-	require.Equal(t, int64(1), sl.counter)
+	<-clientErr
+	<-serverErr
 }
 
-func TestConcurrentRPCCalls(t *testing.T) {
+func TestHandshakeNotSentWithoutCredentials(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	lis, serverConnected, clientConnected := setupConnection(t)
-	defer lis.Close()
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
 
-	cl := &clientLocal{}
-	sl := &serverLocal{}
+	var clientConnected sync.WaitGroup
+	clientConnected.Add(1)
 
-	serverRegistry, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, sl, serverConnected)
-	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+	clientRegistry := NewRegistry[principalServerRemote, json.RawMessage](struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			clientConnected.Done()
+		},
+	})
 
-	cl.ForRemotes = clientRegistry.ForRemotes
-	sl.ForRemotes = serverRegistry.ForRemotes
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- linkConn(ctx, clientRegistry, clientConn, nil)
+	}()
 
-	// Wait for client to connect to server
-	serverConnected.Wait()
 	clientConnected.Wait()
 
-	iterations := 5
-	err := serverRegistry.ForRemotes(func(remoteID string, remote clientRemote) error {
-		length, err := remote.TestCallback(ctx, int64(iterations))
-		require.NoError(t, err)
-		require.Equal(t, length, int64(iterations))
+	go func() {
+		_ = clientRegistry.ForRemotes(func(remoteID string, remote principalServerRemote) error {
+			_, err := remote.GetPrincipal(ctx)
 
-		// Concurrent client calling server
-		clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
-			if _, err := remote.TestSimple(ctx, 3); err != nil {
-				return err
-			}
-			return nil
+			return err
 		})
+	}()
 
-		return nil
-	})
-	require.NoError(t, err)
+	// Peers that don't support handshakes would treat a handshake as a call, so the first message needs to be the call itself
+	var msg Message[json.RawMessage]
+	require.NoError(t, json.NewDecoder(serverConn).Decode(&msg))
+	require.NotNil(t, msg.Request)
 
-	cancel()
-	clientDone.Wait()
-	serverDone.Wait()
+	var req utils.Request[json.RawMessage]
+	require.NoError(t, json.Unmarshal(*msg.Request, &req))
+	require.False(t, req.Handshake)
+	require.Equal(t, "GetPrincipal", req.Function)
 
-	// Verify results
-	require.Equal(t, int64(4), sl.counter)
+	cancel()
+	<-clientErr
 }
 
-func TestNestedRPCFromClientToServer(t *testing.T) {
+func TestPolicies(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	lis, serverConnected, clientConnected := setupConnection(t)
-	defer lis.Close()
+	serverRegistry := NewRegistry[struct{}, json.RawMessage](&policyServerLocal{&policyAdminLocal{}, &policyPublicLocal{}}, &RegistryHooks{
+		VerifyCredentials: func(remoteID string, credentials Metadata) (any, error) {
+			return credentials["role"], nil
+		},
 
-	cl := &clientLocal{
-		Nested: &nestedServiceLocal{},
-	}
-	sl := &serverLocal{
-		Nested: &nestedServiceLocal{},
-	}
+		Policies: map[string]Policy{
+			"Admin.Delete": func(ctx context.Context, call *CallInfo) error {
+				// Policies are also called before the arguments are decoded
+				if len(call.Args) > 0 && call.Args[0] == "root" {
+					return errors.New("can't delete root")
+				}
 
-	_, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, sl, serverConnected)
-	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+				return nil
+			},
+			"Public.*": func(ctx context.Context, call *CallInfo) error {
+				return nil
+			},
+		},
+		PolicyTags: map[string]Policy{
+			"admin": func(ctx context.Context, call *CallInfo) error {
+				if GetPrincipal(ctx) != "admin" {
+					return ErrPermissionDenied
+				}
 
-	cl.ForRemotes = clientRegistry.ForRemotes
+				return nil
+			},
+		},
+	})
 
-	// Wait for client to connect to server
-	serverConnected.Wait()
-	clientConnected.Wait()
+	for _, role := range []string{"admin", "anonymous"} {
+		var clientConnected sync.WaitGroup
+		clientConnected.Add(1)
 
-	err := clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
-		err := remote.Nested.SetValue(ctx, "test value")
-		require.NoError(t, err)
+		clientRegistry := NewRegistry[policyServerRemote, json.RawMessage](struct{}{}, &RegistryHooks{
+			OnClientConnect: func(remoteID string) {
+				clientConnected.Done()
+			},
 
-		value, err := remote.Nested.GetValue(ctx)
-		require.NoError(t, err)
-		require.Equal(t, "test value", value)
+			Credentials: Metadata{"role": role},
 
-		err = remote.Nested.SetValue(ctx, "updated value")
-		require.NoError(t, err)
+			// Closures that the client passed to the server can still be called
+			Policies: map[string]Policy{
+				"*": func(ctx context.Context, call *CallInfo) error {
+					return ErrPermissionDenied
+				},
+			},
+		})
 
-		value, err = remote.Nested.GetValue(ctx)
-		require.NoError(t, err)
-		require.Equal(t, "updated value", value)
+		linkCtx, cancelLinkCtx := context.WithCancel(ctx)
 
-		return nil
-	})
-	require.NoError(t, err)
+		serverErr, clientErr := linkConns(t, linkCtx, serverRegistry, clientRegistry, nil)
 
-	cancel()
-	clientDone.Wait()
-	serverDone.Wait()
+		clientConnected.Wait()
+
+		err := clientRegistry.ForRemotes(func(remoteID string, remote policyServerRemote) error {
+			value, err := remote.Public.Read(ctx)
+			require.NoError(t, err)
+			require.Equal(t, "public", value)
+
+			value, err = remote.Apply(ctx, func(ctx context.Context) (string, error) {
+				return "closure", nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, "closure", value)
+
+			value, err = remote.Admin.Delete(ctx, "1")
+			if role == "admin" {
+				require.NoError(t, err)
+				require.Equal(t, "1", value)
+			} else {
+				require.ErrorIs(t, err, ErrPermissionDenied)
+			}
+
+			_, err = remote.Admin.Delete(ctx, "root")
+			require.ErrorIs(t, err, ErrPermissionDenied)
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		cancelLinkCtx()
+		<-clientErr
+		<-serverErr
+	}
 }
 
-func TestBidirectionalNestedRPC(t *testing.T) {
+func TestLinkHooks(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	lis, serverConnected, clientConnected := setupConnection(t)
-	defer lis.Close()
+	var (
+		registryCalls atomic.Int64
+		linkCalls     atomic.Int64
+	)
+	serverRegistry := NewRegistry[struct{}, json.RawMessage](&policyServerLocal{&policyAdminLocal{}, &policyPublicLocal{}}, &RegistryHooks{
+		Policies: map[string]Policy{
+			"Public.*": func(ctx context.Context, call *CallInfo) error {
+				return nil
+			},
+		},
 
-	cl := &clientLocal{
-		Nested: &nestedServiceLocal{
-			value: "initial client value",
+		ServerInterceptors: []Interceptor{
+			func(ctx context.Context, call *CallInfo, next Invoker) ([]any, error) {
+				registryCalls.Add(1)
+
+				return next(ctx, call)
+			},
 		},
-	}
-	sl := &serverLocal{
-		Nested: &nestedServiceLocal{},
-	}
+	})
 
-	serverRegistry, serverDone := startServer[clientRemote, *serverLocal](t, ctx, lis, sl, serverConnected)
-	clientRegistry, clientDone := startClient[serverRemote, *clientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+	var clientConnected sync.WaitGroup
+	clientConnected.Add(1)
 
-	cl.ForRemotes = clientRegistry.ForRemotes
-	sl.ForRemotes = serverRegistry.ForRemotes
+	clientRegistry := NewRegistry[policyServerRemote, json.RawMessage](struct{}{}, nil)
+
+	serverConn, clientConn := net.Pipe()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		// The hooks of a link apply in addition to the hooks of the registry
+		serverErr <- linkConn(ctx, serverRegistry, serverConn, &LinkHooks{
+			Policies: map[string]Policy{
+				"Admin.*": func(ctx context.Context, call *CallInfo) error {
+					return ErrPermissionDenied
+				},
+				"Public.*": func(ctx context.Context, call *CallInfo) error {
+					return nil
+				},
+			},
+
+			ServerInterceptors: []Interceptor{
+				func(ctx context.Context, call *CallInfo, next Invoker) ([]any, error) {
+					linkCalls.Add(1)
+
+					return next(ctx, call)
+				},
+			},
+		})
+	}()
+
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- linkConn(ctx, clientRegistry, clientConn, &LinkHooks{
+			OnClientConnect: func(remoteID string) {
+				clientConnected.Done()
+			},
+		})
+	}()
 
-	// Wait for client to connect to server
-	serverConnected.Wait()
 	clientConnected.Wait()
 
-	// Test client calling server's nested service
-	err := clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
-		err := remote.Nested.SetValue(ctx, "test server value")
+	err := clientRegistry.ForRemotes(func(remoteID string, remote policyServerRemote) error {
+		value, err := remote.Public.Read(ctx)
 		require.NoError(t, err)
+		require.Equal(t, "public", value)
 
-		value, err := remote.Nested.GetValue(ctx)
-		require.NoError(t, err)
-		require.Equal(t, "test server value", value)
+		_, err = remote.Admin.Delete(ctx, "1")
+		require.ErrorIs(t, err, ErrPermissionDenied)
 
 		return nil
 	})
 	require.NoError(t, err)
 
-	// Test server calling client's nested service
-	err = serverRegistry.ForRemotes(func(remoteID string, remote clientRemote) error {
-		err := remote.Nested.SetValue(ctx, "test client value")
-		require.NoError(t, err)
+	require.Equal(t, int64(1), registryCalls.Load())
+	require.Equal(t, int64(1), linkCalls.Load())
 
-		value, err := remote.Nested.GetValue(ctx)
-		require.NoError(t, err)
-		require.Equal(t, "test client value", value)
+	cancel()
+	<-clientErr
+	<-serverErr
+}
 
-		// Test concurrent access to both nested services
-		var wg sync.WaitGroup
-		wg.Add(2)
+func TestPoliciesBeforeDecodingArgs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	argsDecoded := make(chan struct{}, 3)
+	serverRegistry := NewRegistry[struct{}, json.RawMessage](&policyServerLocal{&policyAdminLocal{}, &policyPublicLocal{}}, &RegistryHooks{
+		PolicyTags: map[string]Policy{
+			"admin": func(ctx context.Context, call *CallInfo) error {
+				if call.Args != nil {
+					argsDecoded <- struct{}{}
+				}
+
+				return ErrPermissionDenied
+			},
+		},
+	})
+
+	var clientConnected sync.WaitGroup
+	clientConnected.Add(1)
+
+	clientRegistry := NewRegistry[policyMismatchedServerRemote, json.RawMessage](struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			clientConnected.Done()
+		},
+	})
+
+	serverErr, clientErr := linkConns(t, ctx, serverRegistry, clientRegistry, nil)
+
+	clientConnected.Wait()
+
+	err := clientRegistry.ForRemotes(func(remoteID string, remote policyMismatchedServerRemote) error {
+		// Remotes that may not call a function don't learn anything about its arguments
+		_, err := remote.Admin.Delete(ctx)
+		require.ErrorIs(t, err, ErrPermissionDenied)
+		require.NotErrorIs(t, err, ErrInvalidArgsCount)
 
+		// Streams that are passed to functions that the remote may not call are ended
+		ids := make(chan string)
 		go func() {
-			defer wg.Done()
-			value, err := remote.Nested.GetValue(ctx)
-			require.NoError(t, err)
-			require.Equal(t, "test client value", value)
+			defer close(ids)
+
+			ids <- "1"
 		}()
 
+		_, err = remote.Admin.Import(ctx, ids)
+		require.ErrorIs(t, err, ErrPermissionDenied)
+
+		exported := make(chan string)
+		exportErr := make(chan error)
 		go func() {
-			defer wg.Done()
-			err := clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
-				value, err := remote.Nested.GetValue(ctx)
-				require.NoError(t, err)
-				require.Equal(t, "test server value", value)
-				return nil
-			})
-			require.NoError(t, err)
+			exportErr <- remote.Admin.Export(ctx, exported)
 		}()
 
-		wg.Wait()
-		return nil
-	})
-	require.NoError(t, err)
-
-	// Test nested service state persistence
-	err = clientRegistry.ForRemotes(func(remoteID string, remote serverRemote) error {
-		value, err := remote.Nested.GetValue(ctx)
-		require.NoError(t, err)
-		require.Equal(t, "test server value", value)
-		return nil
-	})
-	require.NoError(t, err)
+		for range exported {
+		}
+		require.ErrorIs(t, <-exportErr, ErrPermissionDenied)
 
-	err = serverRegistry.ForRemotes(func(remoteID string, remote clientRemote) error {
-		value, err := remote.Nested.GetValue(ctx)
-		require.NoError(t, err)
-		require.Equal(t, "test client value", value)
 		return nil
 	})
 	require.NoError(t, err)
+	require.Empty(t, argsDecoded)
 
 	cancel()
-	clientDone.Wait()
-	serverDone.Wait()
+	<-clientErr
+	<-serverErr
 }
 
-func TestRPCWithClosureOnServerFromClient(t *testing.T) {
+func TestPoliciesForPromotedMethods(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	lis, serverConnected, clientConnected := setupConnection(t)
-	defer lis.Close()
+	serverRegistry := NewRegistry[struct{}, json.RawMessage](&policyPromotingServerLocal{&policyAdminLocal{}, policyPublicLocal{}}, &RegistryHooks{
+		VerifyCredentials: func(remoteID string, credentials Metadata) (any, error) {
+			return credentials["role"], nil
+		},
 
-	cl := &closureClientLocal{}
-	sl := &closureServerLocal{}
+		// Promoted methods are matched by the function call path through the embedded field, too
+		Policies: map[string]Policy{
+			"policyAdminLocal.Delete": func(ctx context.Context, call *CallInfo) error {
+				if len(call.Args) > 0 && call.Args[0] == "root" {
+					return errors.New("can't delete root")
+				}
 
-	_, serverDone := startServer[closureClientRemote, *closureServerLocal](t, ctx, lis, sl, serverConnected)
-	clientRegistry, clientDone := startClient[closureServerRemote, *closureClientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+				return nil
+			},
+		},
+		PolicyTags: map[string]Policy{
+			"admin": func(ctx context.Context, call *CallInfo) error {
+				if GetPrincipal(ctx) != "admin" {
+					return ErrPermissionDenied
+				}
 
-	cl.ForRemotes = clientRegistry.ForRemotes
+				return nil
+			},
+		},
+	})
 
-	// Wait for client to connect to server
-	serverConnected.Wait()
-	clientConnected.Wait()
+	for _, role := range []string{"admin", "anonymous"} {
+		var clientConnected sync.WaitGroup
+		clientConnected.Add(1)
 
-	// Test iterations
-	expectedIterations := 5
-	actualIterations := 0
+		clientRegistry := NewRegistry[policyPromotingServerRemote, json.RawMessage](struct{}{}, &RegistryHooks{
+			OnClientConnect: func(remoteID string) {
+				clientConnected.Done()
+			},
 
-	if err := clientRegistry.ForRemotes(func(remoteID string, remote closureServerRemote) error {
-		count, err := remote.Iterate(ctx, expectedIterations, func(ctx context.Context, i int, b string) (string, error) {
-			actualIterations++
+			Credentials: Metadata{"role": role},
+		})
 
-			return "This is from the caller", nil
+		linkCtx, cancelLinkCtx := context.WithCancel(ctx)
+
+		serverErr, clientErr := linkConns(t, linkCtx, serverRegistry, clientRegistry, nil)
+
+		clientConnected.Wait()
+
+		err := clientRegistry.ForRemotes(func(remoteID string, remote policyPromotingServerRemote) error {
+			value, err := remote.Read(ctx)
+			require.NoError(t, err)
+			require.Equal(t, "public", value)
+
+			// The policies of embedded fields apply to the methods that are promoted from them
+			value, err = remote.Delete(ctx, "1")
+			if role == "admin" {
+				require.NoError(t, err)
+				require.Equal(t, "1", value)
+			} else {
+				require.ErrorIs(t, err, ErrPermissionDenied)
+			}
+
+			_, err = remote.Delete(ctx, "root")
+			require.ErrorIs(t, err, ErrPermissionDenied)
+
+			return nil
 		})
 		require.NoError(t, err)
 
-		require.Equal(t, expectedIterations, count)
-		return nil
-	}); err != nil {
-		require.NoError(t, err)
+		cancelLinkCtx()
+		<-clientErr
+		<-serverErr
 	}
-
-	// Cleanup
-	cancel()
-	clientDone.Wait()
-	serverDone.Wait()
-
-	require.Equal(t, expectedIterations, actualIterations)
 }
 
-func TestRPCWithClosureOnClientFromServer(t *testing.T) {
+func TestPeer(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	lis, serverConnected, clientConnected := setupConnection(t)
+	_, ok := RemoteIDFromContext(ctx)
+	require.False(t, ok)
+
+	_, ok = PeerFromContext(ctx)
+	require.False(t, ok)
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
 	defer lis.Close()
 
-	cl := &closureClientLocal{}
-	sl := &closureServerLocal{}
+	serverRegistry := NewRegistry[struct{}, json.RawMessage](&peerServerLocal{}, nil)
 
-	serverRegistry, serverDone := startServer[closureClientRemote, *closureServerLocal](t, ctx, lis, sl, serverConnected)
-	_, clientDone := startClient[closureServerRemote, *closureClientLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+	var clientConnected sync.WaitGroup
+	clientConnected.Add(1)
 
-	sl.ForRemotes = serverRegistry.ForRemotes
+	clientRegistry := NewRegistry[peerServerRemote, json.RawMessage](struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			clientConnected.Done()
+		},
+	})
 
-	// Wait for client to connect to server
-	serverConnected.Wait()
-	clientConnected.Wait()
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			serverErr <- err
 
-	// Test iterations
-	expectedIterations := 5
-	actualIterations := 0
+			return
+		}
 
-	if err := serverRegistry.ForRemotes(func(remoteID string, remote closureClientRemote) error {
-		count, err := remote.Iterate(ctx, expectedIterations, func(ctx context.Context, i int, b string) (string, error) {
-			actualIterations++
+		serverErr <- linkConn(WithPeer(ctx, &Peer{
+			Transport: "tcp",
 
-			return "This is from the caller", nil
-		})
+			LocalAddr:  conn.LocalAddr(),
+			RemoteAddr: conn.RemoteAddr(),
+		}), serverRegistry, conn, nil)
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	require.NoError(t, err)
+
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- linkConn(ctx, clientRegistry, conn, nil)
+	}()
+
+	clientConnected.Wait()
+
+	err = clientRegistry.ForRemotes(func(remoteID string, remote peerServerRemote) error {
+		transport, remoteAddr, err := remote.GetPeer(ctx)
 		require.NoError(t, err)
+		require.Equal(t, "tcp", transport)
+		require.Equal(t, conn.LocalAddr().String(), remoteAddr)
 
-		require.Equal(t, expectedIterations, count)
 		return nil
-	}); err != nil {
-		require.NoError(t, err)
-	}
+	})
+	require.NoError(t, err)
 
-	// Cleanup
 	cancel()
-	clientDone.Wait()
-	serverDone.Wait()
-
-	require.Equal(t, expectedIterations, actualIterations)
+	<-clientErr
+	<-serverErr
 }
 
-func TestRPCsWithReturnValueEdgecases(t *testing.T) {
+func TestClosuresAreScopedToLink(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	lis, serverConnected, clientConnected := setupConnection(t)
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
 	defer lis.Close()
 
-	sl := &returnValueLocal{}
-	cl := &returnValueLocal{}
+	var serverConnected, firstClientConnected, secondClientConnected sync.WaitGroup
+	serverConnected.Add(2)
+	firstClientConnected.Add(1)
+	secondClientConnected.Add(1)
 
-	serverRegistry, serverDone := startServer[returnValueRemote, *returnValueLocal](t, ctx, lis, sl, serverConnected)
-	clientRegistry, clientDone := startClient[returnValueRemote, *returnValueLocal](t, ctx, lis.Addr().String(), cl, clientConnected)
+	serverRegistry := NewRegistry[isolationClientRemote, json.RawMessage](
+		struct{}{},
 
-	sl.ForRemotes = serverRegistry.ForRemotes
-	cl.ForRemotes = clientRegistry.ForRemotes
+		&RegistryHooks{
+			OnClientConnect: func(remoteID string) {
+				serverConnected.Done()
+			},
+		},
+	)
+
+	firstServerDone := acceptLink(t, ctx, lis, serverRegistry)
+	firstCl := &isolationClientLocal{
+		closureIDs:      make(chan string),
+		releaseClosures: make(chan struct{}),
+	}
+	firstClientRegistry, firstClientDone := startClient[isolationServerRemote](t, ctx, lis.Addr().String(), firstCl, &firstClientConnected)
+	firstClientConnected.Wait()
+
+	secondServerDone := acceptLink(t, ctx, lis, serverRegistry)
+	secondCl := &isolationClientLocal{
+		closureIDs:      make(chan string),
+		releaseClosures: make(chan struct{}),
+	}
+	secondClientRegistry, secondClientDone := startClient[isolationServerRemote](t, ctx, lis.Addr().String(), secondCl, &secondClientConnected)
+	secondClientConnected.Wait()
 
-	// Wait for client to connect to server
 	serverConnected.Wait()
-	clientConnected.Wait()
 
-	err := clientRegistry.ForRemotes(func(remoteID string, remote returnValueRemote) error {
-		// Test single error return (success case)
-		err := remote.TestSingleError(ctx, false)
-		require.NoError(t, err)
+	// Register a closure on each of the server's links
+	remotes := []isolationClientRemote{}
+	require.NoError(t, serverRegistry.ForRemotes(func(remoteID string, remote isolationClientRemote) error {
+		remotes = append(remotes, remote)
 
-		// Test single error return (error case)
-		err = remote.TestSingleError(ctx, true)
-		require.Error(t, err)
-		require.Contains(t, err.Error(), errTest.Error())
+		return nil
+	}))
 
-		// Test value and error (success case)
-		val, err := remote.TestValueAndError(ctx, false)
-		require.NoError(t, err)
-		require.Equal(t, int64(2), val)
+	var calls atomic.Int64
+	serverErrs := make(chan error, len(remotes))
+	for _, remote := range remotes {
+		go func(remote isolationClientRemote) {
+			serverErrs <- remote.TestLeakClosure(ctx, func(ctx context.Context) error {
+				calls.Add(1)
 
-		// Test value and error (error case)
-		val, err = remote.TestValueAndError(ctx, true)
-		require.Error(t, err)
-		require.Contains(t, err.Error(), errTest.Error())
-		require.Equal(t, int64(0), val)
+				return nil
+			})
+		}(remote)
+	}
 
-		return nil
+	firstClosureID := <-firstCl.closureIDs
+	secondClosureID := <-secondCl.closureIDs
+
+	// The second client can't call a closure that was registered for the first client
+	err = secondClientRegistry.ForRemotes(func(remoteID string, remote isolationServerRemote) error {
+		_, err := remote.CallClosure(ctx, firstClosureID, []interface{}{})
+
+		return err
+	})
+	require.ErrorIs(t, err, ErrClosureDoesNotExist)
+
+	// But both clients can call their own closures
+	err = secondClientRegistry.ForRemotes(func(remoteID string, remote isolationServerRemote) error {
+		_, err := remote.CallClosure(ctx, secondClosureID, []interface{}{})
+
+		return err
+	})
+	require.NoError(t, err)
+
+	err = firstClientRegistry.ForRemotes(func(remoteID string, remote isolationServerRemote) error {
+		_, err := remote.CallClosure(ctx, firstClosureID, []interface{}{})
+
+		return err
 	})
 	require.NoError(t, err)
+	require.Equal(t, int64(2), calls.Load())
+
+	close(firstCl.releaseClosures)
+	close(secondCl.releaseClosures)
+	for range remotes {
+		require.NoError(t, <-serverErrs)
+	}
 
 	cancel()
-	clientDone.Wait()
-	serverDone.Wait()
+	firstClientDone.Wait()
+	secondClientDone.Wait()
+	firstServerDone.Wait()
+	secondServerDone.Wait()
 }
 
 func TestRegistryHooksInitialization(t *testing.T) {
@@ -1089,6 +4519,108 @@ func TestRemoteImplementationInvalidReturnNoError(t *testing.T) {
 	require.ErrorIs(t, err, ErrInvalidReturn)
 }
 
+func TestRemoteImplementationInvalidPipeline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := NewRegistry[remoteInvalidPipeline, json.RawMessage](struct{}{}, nil)
+
+	err := r.LinkStream(
+		ctx,
+		func(v Message[json.RawMessage]) error { return nil },
+		func(v *Message[json.RawMessage]) error {
+			<-ctx.Done()
+
+			return ctx.Err()
+		},
+		func(v any) (json.RawMessage, error) { return nil, nil },
+		func(data json.RawMessage, v any) error { return nil },
+		nil,
+	)
+
+	require.ErrorIs(t, err, ErrInvalidReturn)
+}
+
+func TestRemoteImplementationInvalidNotify(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := NewRegistry[remoteInvalidNotify, json.RawMessage](struct{}{}, nil)
+
+	err := r.LinkStream(
+		ctx,
+		func(v Message[json.RawMessage]) error { return nil },
+		func(v *Message[json.RawMessage]) error {
+			<-ctx.Done()
+
+			return ctx.Err()
+		},
+		func(v any) (json.RawMessage, error) { return nil, nil },
+		func(data json.RawMessage, v any) error { return nil },
+		nil,
+	)
+
+	require.ErrorIs(t, err, ErrInvalidReturn)
+}
+
+func TestRemoteImplementationInvalidNotifyClosure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := NewRegistry[remoteInvalidNotifyClosure, json.RawMessage](struct{}{}, nil)
+
+	err := r.LinkStream(
+		ctx,
+		func(v Message[json.RawMessage]) error { return nil },
+		func(v *Message[json.RawMessage]) error {
+			<-ctx.Done()
+
+			return ctx.Err()
+		},
+		func(v any) (json.RawMessage, error) { return nil, nil },
+		func(data json.RawMessage, v any) error { return nil },
+		nil,
+	)
+
+	require.ErrorIs(t, err, ErrClosureInNotification)
+}
+
+func TestRemoteImplementationNotifyCallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var connected sync.WaitGroup
+	connected.Add(1)
+
+	r := NewRegistry[remoteNotifyCallback, json.RawMessage](struct{}{}, &RegistryHooks{
+		OnClientConnect: func(remoteID string) {
+			connected.Done()
+		},
+	})
+
+	// Callbacks stay registered until the remote releases them, so notifications can take them
+	errs := make(chan error, 1)
+	go func() {
+		errs <- r.LinkStream(
+			ctx,
+			func(v Message[json.RawMessage]) error { return nil },
+			func(v *Message[json.RawMessage]) error {
+				<-ctx.Done()
+
+				return ctx.Err()
+			},
+			func(v any) (json.RawMessage, error) { return nil, nil },
+			func(data json.RawMessage, v any) error { return nil },
+			nil,
+		)
+	}()
+
+	connected.Wait()
+	cancel()
+
+	require.ErrorIs(t, <-errs, context.Canceled)
+}
+
 func TestRemoteImplementationInvalidArgsNoInputs(t *testing.T) {
 	r := NewRegistry[remoteNoInputs, json.RawMessage](struct{}{}, nil)
 
@@ -1275,3 +4807,57 @@ func TestFindMethodByFunctionCallPathRecursively(t *testing.T) {
 		})
 	}
 }
+
+type policyPathInner struct {
+	Admin *policyAdminLocal `panrpc:"policy=admin"`
+}
+
+type policyPathMiddle struct {
+	*policyAdminLocal `panrpc:"policy=middle"`
+}
+
+type policyPathOuter struct {
+	policyPathInner `panrpc:"policy=inner"`
+	*policyPathMiddle
+}
+
+func TestResolveFunctionCallPath(t *testing.T) {
+	tests := []struct {
+		name             string
+		functionCallPath string
+		expectedPath     string
+		expectedTags     []string
+	}{
+		{
+			name:             "field promoted from an embedded field",
+			functionCallPath: "Admin.Delete",
+			expectedPath:     "policyPathInner.Admin.Delete",
+			expectedTags:     []string{"policy=inner", "policy=admin"},
+		},
+		{
+			name:             "method promoted through multiple embedded fields",
+			functionCallPath: "Delete",
+			expectedPath:     "policyPathMiddle.policyAdminLocal.Delete",
+			expectedTags:     []string{"", "policy=middle"},
+		},
+		{
+			name:             "unknown field",
+			functionCallPath: "Unknown.Delete",
+			expectedPath:     "Unknown.Delete",
+			expectedTags:     []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, path := resolveFunctionCallPath(&policyPathOuter{}, tt.functionCallPath)
+			require.Equal(t, tt.expectedPath, path)
+
+			tags := []string{}
+			for _, field := range fields {
+				tags = append(tags, field.Tag.Get("panrpc"))
+			}
+			require.Equal(t, tt.expectedTags, tags)
+		})
+	}
+}
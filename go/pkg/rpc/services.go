@@ -0,0 +1,100 @@
+package rpc
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+var (
+	ErrInvalidService           = errors.New("invalid service, can only register functions or structs")
+	ErrServiceAlreadyRegistered = errors.New("service is already registered")
+	ErrServiceNotRegistered     = errors.New("service is not registered")
+)
+
+// Register exposes `service` at the function call path `path` in addition to the local RPCs, e.g. `Plugins.Foo`. If `service`
+// is a struct, its methods can be called with `path` as the prefix, e.g. `Plugins.Foo.Println`; if it is a function, it
+// can be called with `path` itself. Services take precedence over local RPCs with the same function call path and are
+// available to all links, including those that are already connected, until they are removed with `Unregister`.
+func (r Registry[R, T]) Register(
+	path string, // Function call path to register the service at
+	service any, // Struct or function to expose
+) error {
+	if strings.TrimSpace(path) == "" {
+		return ErrInvalidFunctionCallPath
+	}
+
+	v := reflect.ValueOf(service)
+	if !v.IsValid() {
+		return ErrInvalidService
+	}
+
+	if t := v.Type(); t.Kind() != reflect.Func && t.Kind() != reflect.Struct && !(t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct) {
+		return ErrInvalidService
+	}
+
+	r.servicesLock.Lock()
+	defer r.servicesLock.Unlock()
+
+	if _, ok := r.services[path]; ok {
+		return ErrServiceAlreadyRegistered
+	}
+
+	r.services[path] = v
+
+	return nil
+}
+
+// Unregister removes the service registered at the function call path `path`. Calls to it that are already in-flight are not cancelled.
+func (r Registry[R, T]) Unregister(
+	path string, // Function call path the service was registered at
+) error {
+	r.servicesLock.Lock()
+	defer r.servicesLock.Unlock()
+
+	if _, ok := r.services[path]; !ok {
+		return ErrServiceNotRegistered
+	}
+
+	delete(r.services, path)
+
+	return nil
+}
+
+// findRegisteredService finds the registered service that the function with the call path `functionCallPath` belongs to, preferring the
+// service with the longest matching path. It returns the service and the call path of the function relative to it, which is empty for functions.
+func (r Registry[R, T]) findRegisteredService(functionCallPath string) (service reflect.Value, path string, err error) {
+	r.servicesLock.RLock()
+	defer r.servicesLock.RUnlock()
+
+	functionCallPathParts := strings.Split(functionCallPath, ".")
+	for i := len(functionCallPathParts); i > 0; i-- {
+		service, ok := r.services[strings.Join(functionCallPathParts[:i], ".")]
+		if !ok {
+			continue
+		}
+
+		// Functions can only be called with their own path, and services only with the path of one of their methods
+		if (service.Kind() == reflect.Func) != (i == len(functionCallPathParts)) {
+			continue
+		}
+
+		return service, strings.Join(functionCallPathParts[i:], "."), nil
+	}
+
+	return reflect.Value{}, "", ErrCannotCallNonFunction
+}
+
+// findRegisteredFunction finds the function with the call path `functionCallPath` in the registered services
+func (r Registry[R, T]) findRegisteredFunction(functionCallPath string) (reflect.Value, error) {
+	service, path, err := r.findRegisteredService(functionCallPath)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	if path == "" {
+		return service, nil
+	}
+
+	return findMethodByFunctionCallPathRecursively(service.Interface(), path)
+}
@@ -0,0 +1,768 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/pojntfx/panrpc/go/pkg/utils"
+)
+
+// streamWindowLen is the number of values of a stream that can be sent before the remote has received them
+const streamWindowLen = 64
+
+var (
+	ErrStreamWindowExceeded = errors.New("stream window exceeded, the remote sent more values than it had credit for")
+)
+
+type streamKind int
+
+const (
+	streamKindNone streamKind = iota
+	streamKindChan            // `<-chan T` or `chan T`, which is closed without an error if the stream ends with one
+	streamKindSeq             // `iter.Seq[T]`, or `func(yield func(T) bool)`
+	streamKindSeq2            // `iter.Seq2[T, error]`, or `func(yield func(T, error) bool)`
+)
+
+// getStreamKind returns the kind of stream a function can return as type `t`
+func getStreamKind(t reflect.Type) streamKind {
+	switch t.Kind() {
+	case reflect.Chan:
+		if t.ChanDir()&reflect.RecvDir != 0 {
+			return streamKindChan
+		}
+
+	case reflect.Func:
+		if t.NumIn() != 1 || t.NumOut() != 0 || t.IsVariadic() {
+			return streamKindNone
+		}
+
+		yield := t.In(0)
+		if yield.Kind() != reflect.Func || yield.NumOut() != 1 || yield.Out(0).Kind() != reflect.Bool || yield.IsVariadic() {
+			return streamKindNone
+		}
+
+		switch yield.NumIn() {
+		case 1:
+			return streamKindSeq
+
+		case 2:
+			if yield.In(1) == errorType {
+				return streamKindSeq2
+			}
+		}
+	}
+
+	return streamKindNone
+}
+
+// isSendChan returns whether type `t` is a send-only channel, which a function can take as an argument to send a stream of values to the caller
+func isSendChan(t reflect.Type) bool {
+	return t.Kind() == reflect.Chan && t.ChanDir() == reflect.SendDir
+}
+
+// incomingStream is a queue of the values of a stream that was sent by a remote.
+// The remote can only send as many values as the queue has credit for, so its length is bounded by `streamWindowLen`;
+// if it sends more, the stream is closed with `ErrStreamWindowExceeded`.
+type incomingStream[T any] struct {
+	id    string
+	write func(msg *utils.Stream[T]) error // Function to send credits and cancellations to the producer with
+
+	lock     sync.Mutex
+	values   []T
+	credit   int64 // Number of values that the producer can still send
+	consumed int64 // Number of values that were consumed since the last credit was sent
+	done     bool
+	err      error
+
+	notify chan struct{}
+}
+
+func newIncomingStream[T any](id string, write func(msg *utils.Stream[T]) error) *incomingStream[T] {
+	return &incomingStream[T]{
+		id:    id,
+		write: write,
+
+		credit: streamWindowLen,
+
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (s *incomingStream[T]) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *incomingStream[T]) push(value T) {
+	s.lock.Lock()
+	if s.done {
+		s.lock.Unlock()
+
+		return
+	}
+
+	if s.credit <= 0 {
+		// The values that were received so far can still be consumed, but the producer needs to stop sending values
+		s.done = true
+		s.err = ErrStreamWindowExceeded
+		s.lock.Unlock()
+
+		s.signal()
+
+		// If this fails, the link is closed and the stream ends anyways
+		_ = s.write(&utils.Stream[T]{
+			ID:     s.id,
+			Cancel: true,
+		})
+
+		return
+	}
+
+	s.credit--
+	s.values = append(s.values, value)
+	s.lock.Unlock()
+
+	s.signal()
+}
+
+func (s *incomingStream[T]) close(err error) {
+	s.lock.Lock()
+	if s.done {
+		s.lock.Unlock()
+
+		return
+	}
+
+	s.done = true
+	s.err = err
+	s.lock.Unlock()
+
+	s.signal()
+}
+
+// next blocks until the next value of the stream is available; `ok` is false once the stream has ended or `ctx` is cancelled
+func (s *incomingStream[T]) next(ctx context.Context) (value T, ok bool, err error) {
+	for {
+		s.lock.Lock()
+		if len(s.values) > 0 {
+			value = s.values[0]
+			s.values[0] = *new(T) // Don't keep a reference to the value around
+			s.values = s.values[1:]
+
+			// Batch credits so that we don't have to send one message for every value
+			var credit int64
+			s.consumed++
+			if s.consumed >= streamWindowLen/2 && !s.done {
+				credit = s.consumed
+				s.credit += credit
+				s.consumed = 0
+			}
+			s.lock.Unlock()
+
+			if credit > 0 {
+				// If this fails, the link is closed and the stream ends anyways
+				_ = s.write(&utils.Stream[T]{
+					ID:     s.id,
+					Credit: credit,
+				})
+			}
+
+			return value, true, nil
+		}
+
+		if s.done {
+			err = s.err
+			s.lock.Unlock()
+
+			return value, false, err
+		}
+		s.lock.Unlock()
+
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return value, false, ctx.Err()
+		}
+	}
+}
+
+// outgoingStream keeps track of how many values of a stream can be sent before the remote has received them
+type outgoingStream struct {
+	lock      sync.Mutex
+	credit    int64
+	cancelled bool
+
+	notify chan struct{}
+}
+
+func newOutgoingStream() *outgoingStream {
+	return &outgoingStream{
+		credit: streamWindowLen,
+
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (s *outgoingStream) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *outgoingStream) grant(credit int64) {
+	s.lock.Lock()
+	s.credit += credit
+	s.lock.Unlock()
+
+	s.signal()
+}
+
+func (s *outgoingStream) cancel() {
+	s.lock.Lock()
+	s.cancelled = true
+	s.lock.Unlock()
+
+	s.signal()
+}
+
+// acquire blocks until the remote is ready to receive another value; `ok` is false if it isn't interested in the stream anymore
+func (s *outgoingStream) acquire(ctx context.Context) (ok bool, err error) {
+	for {
+		s.lock.Lock()
+		if s.cancelled {
+			s.lock.Unlock()
+
+			return false, nil
+		}
+
+		if s.credit > 0 {
+			s.credit--
+			s.lock.Unlock()
+
+			return true, nil
+		}
+		s.lock.Unlock()
+
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// streamManager keeps track of the streams of a link
+type streamManager[T any] struct {
+	lock     sync.Mutex
+	incoming map[string]*incomingStream[T]
+	outgoing map[string]*outgoingStream
+
+	closed bool
+	err    error
+}
+
+func newStreamManager[T any]() *streamManager[T] {
+	return &streamManager[T]{
+		incoming: map[string]*incomingStream[T]{},
+		outgoing: map[string]*outgoingStream{},
+	}
+}
+
+func (m *streamManager[T]) registerIncoming(streamID string, write func(msg *utils.Stream[T]) error) *incomingStream[T] {
+	stream := newIncomingStream[T](streamID, write)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		stream.close(m.err)
+
+		return stream
+	}
+
+	m.incoming[streamID] = stream
+
+	return stream
+}
+
+func (m *streamManager[T]) registerOutgoing(streamID string) *outgoingStream {
+	stream := newOutgoingStream()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		stream.cancel()
+
+		return stream
+	}
+
+	m.outgoing[streamID] = stream
+
+	return stream
+}
+
+func (m *streamManager[T]) getIncoming(streamID string) (*incomingStream[T], bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stream, ok := m.incoming[streamID]
+
+	return stream, ok
+}
+
+func (m *streamManager[T]) getOutgoing(streamID string) (*outgoingStream, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stream, ok := m.outgoing[streamID]
+
+	return stream, ok
+}
+
+func (m *streamManager[T]) freeIncoming(streamID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.incoming, streamID)
+}
+
+func (m *streamManager[T]) freeOutgoing(streamID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.outgoing, streamID)
+}
+
+// close ends all incoming streams with `err` and stops all outgoing streams
+func (m *streamManager[T]) close(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		return
+	}
+
+	m.closed = true
+	m.err = err
+
+	for streamID, stream := range m.incoming {
+		stream.close(err)
+
+		delete(m.incoming, streamID)
+	}
+
+	for streamID, stream := range m.outgoing {
+		stream.cancel()
+
+		delete(m.outgoing, streamID)
+	}
+}
+
+// streamWriter returns a function to send stream messages to the remote with.
+// Messages from the caller's side of a call are sent as requests, messages from the callee's side as responses.
+func (l *link[T]) streamWriter(asCaller bool) func(msg *utils.Stream[T]) error {
+	if asCaller {
+		return func(msg *utils.Stream[T]) error {
+			req := &utils.Request[T]{
+				Call:   msg.ID,
+				Stream: msg,
+			}
+
+			b, err := req.Marshal(l.marshal)
+			if err != nil {
+				return err
+			}
+
+			return l.writeRequest(b)
+		}
+	}
+
+	return func(msg *utils.Stream[T]) error {
+		res := &utils.Response[T]{
+			Call:   msg.ID,
+			Stream: msg,
+		}
+
+		b, err := res.Marshal(l.marshal)
+		if err != nil {
+			return err
+		}
+
+		return l.writeResponse(b)
+	}
+}
+
+// handleStreamMessage routes a stream message that was sent by the remote to its stream
+func (l *link[T]) handleStreamMessage(msg *utils.Stream[T]) {
+	if msg.Credit > 0 || msg.Cancel {
+		stream, ok := l.streams.getOutgoing(msg.ID)
+		if !ok {
+			// The stream has already ended
+			return
+		}
+
+		if msg.Cancel {
+			stream.cancel()
+		} else {
+			stream.grant(msg.Credit)
+		}
+
+		return
+	}
+
+	stream, ok := l.streams.getIncoming(msg.ID)
+	if !ok {
+		// The consumer isn't interested in the stream anymore
+		return
+	}
+
+	if !msg.Close {
+		stream.push(msg.Value)
+
+		return
+	}
+
+	var err error
+	if msg.Err != "" {
+		err = decodeError(msg.Err, msg.Code, msg.Details, l.unmarshal)
+	}
+
+	stream.close(err)
+	l.streams.freeIncoming(msg.ID)
+}
+
+// sendStream sends the values of `stream`, which is a channel or an iterator, to the remote until it ends,
+// the remote cancels it or `ctx` is cancelled. If `stop` is set, the stream also ends once `stop` is closed,
+// and values that are sent to the channel after the remote cancelled the stream are discarded until then.
+// Only errors that are fatal for the link are returned; all others end the stream.
+func (l *link[T]) sendStream(
+	ctx context.Context,
+
+	streamID string,
+	stream reflect.Value,
+	outgoing *outgoingStream,
+
+	write func(msg *utils.Stream[T]) error,
+	stop <-chan struct{},
+) error {
+	defer l.streams.freeOutgoing(streamID)
+
+	var (
+		streamErr error
+		cancelled bool
+	)
+
+	// sendValue returns false if the stream should end
+	sendValue := func(value reflect.Value) (bool, error) {
+		ok, err := outgoing.acquire(ctx)
+		if err != nil {
+			streamErr = err
+
+			return false, nil
+		}
+
+		if !ok {
+			cancelled = true
+
+			return false, nil
+		}
+
+		v, err := l.marshal(value.Interface())
+		if err != nil {
+			streamErr = err
+
+			return false, nil
+		}
+
+		if err := write(&utils.Stream[T]{
+			ID:    streamID,
+			Value: v,
+		}); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	if !stream.IsNil() {
+		switch kind := getStreamKind(stream.Type()); kind {
+		case streamKindChan:
+			cases := []reflect.SelectCase{
+				{Dir: reflect.SelectRecv, Chan: stream},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+			}
+			if stop != nil {
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(stop)})
+			}
+
+		loop:
+			for {
+				chosen, value, ok := reflect.Select(cases)
+				switch {
+				case chosen == 1:
+					if !cancelled {
+						streamErr = ctx.Err()
+					}
+
+					break loop
+
+				case chosen == 2, !ok:
+					break loop
+
+				case cancelled || streamErr != nil:
+					// Don't block the sender of the values
+					continue
+				}
+
+				cont, err := sendValue(value)
+				if err != nil {
+					return err
+				}
+
+				if !cont && stop == nil {
+					break loop
+				}
+			}
+
+		case streamKindSeq, streamKindSeq2:
+			var (
+				writeErr error
+				stopped  bool
+			)
+			yield := reflect.MakeFunc(stream.Type().In(0), func(args []reflect.Value) []reflect.Value {
+				if stopped {
+					return []reflect.Value{reflect.ValueOf(false)}
+				}
+
+				if kind == streamKindSeq2 && !args[1].IsNil() {
+					streamErr = args[1].Interface().(error)
+				} else {
+					cont, err := sendValue(args[0])
+					if err != nil {
+						writeErr = err
+					}
+
+					if cont {
+						return []reflect.Value{reflect.ValueOf(true)}
+					}
+				}
+
+				stopped = true
+
+				return []reflect.Value{reflect.ValueOf(false)}
+			})
+
+			if _, err := utils.Call(stream, []reflect.Value{yield}); err != nil {
+				streamErr = err
+			}
+
+			if writeErr != nil {
+				return writeErr
+			}
+		}
+	}
+
+	if cancelled {
+		// The remote isn't interested in the end of the stream
+		return nil
+	}
+
+	msg := &utils.Stream[T]{
+		ID:    streamID,
+		Close: true,
+	}
+
+	if streamErr != nil {
+		var err error
+		msg.Err, msg.Code, msg.Details, err = encodeError(streamErr, l.marshal)
+		if err != nil {
+			return err
+		}
+	}
+
+	return write(msg)
+}
+
+// receiveStream converts `stream` into a channel or iterator of type `streamType`.
+// `release` is called once the consumer is done with the stream; `stopped` is true if it stopped before the stream ended.
+func (l *link[T]) receiveStream(ctx context.Context, stream *incomingStream[T], streamType reflect.Type, release func(stopped bool)) reflect.Value {
+	switch kind := getStreamKind(streamType); kind {
+	case streamKindChan:
+		ch := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, streamType.Elem()), 0)
+		go l.receiveStreamInto(ctx, stream, ch, release)
+
+		return ch.Convert(streamType)
+
+	case streamKindSeq, streamKindSeq2:
+		elemType := streamType.In(0).In(0)
+
+		var (
+			once                 sync.Once
+			stopReleaseAfterFunc func() bool
+		)
+		releaseOnce := func(stopped bool) {
+			once.Do(func() {
+				if stopReleaseAfterFunc != nil {
+					stopReleaseAfterFunc()
+				}
+
+				release(stopped)
+			})
+		}
+
+		// Iterators might never be called, so we need to release them once `ctx` is cancelled
+		stopReleaseAfterFunc = context.AfterFunc(ctx, func() {
+			releaseOnce(true)
+		})
+
+		return reflect.MakeFunc(streamType, func(args []reflect.Value) []reflect.Value {
+			yield := args[0]
+
+			yieldErr := func(err error) {
+				if kind == streamKindSeq2 {
+					yield.Call([]reflect.Value{reflect.Zero(elemType), reflect.ValueOf(&err).Elem()})
+				}
+			}
+
+			for {
+				raw, ok, err := stream.next(ctx)
+				if !ok {
+					releaseOnce(ctx.Err() != nil)
+
+					if err != nil {
+						yieldErr(err)
+					}
+
+					return nil
+				}
+
+				value := reflect.New(elemType)
+				if err := l.unmarshal(raw, value.Interface()); err != nil {
+					releaseOnce(true)
+
+					yieldErr(err)
+
+					return nil
+				}
+
+				in := []reflect.Value{value.Elem()}
+				if kind == streamKindSeq2 {
+					in = append(in, reflect.Zero(errorType))
+				}
+
+				if !yield.Call(in)[0].Bool() {
+					releaseOnce(true)
+
+					return nil
+				}
+			}
+		})
+	}
+
+	return reflect.Zero(streamType)
+}
+
+// receiveStreamInto sends the values of `stream` to the channel `ch` and closes it once the stream has ended.
+// Channels can't carry errors, so if the stream ended with an error, e.g. because the remote returned one or the link
+// was closed, the channel is closed just like at the normal end of the stream; `iter.Seq2[T, error]` yields such errors.
+// `release` is called once the stream has ended; `stopped` is true if it stopped before the stream ended.
+func (l *link[T]) receiveStreamInto(ctx context.Context, stream *incomingStream[T], ch reflect.Value, release func(stopped bool)) {
+	defer ch.Close()
+
+	elemType := ch.Type().Elem()
+	for {
+		raw, ok, _ := stream.next(ctx)
+		if !ok {
+			release(ctx.Err() != nil)
+
+			return
+		}
+
+		value := reflect.New(elemType)
+		if err := l.unmarshal(raw, value.Interface()); err != nil {
+			release(true)
+
+			return
+		}
+
+		chosen, _, _ := reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: ch, Send: value.Elem()},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		})
+		if chosen == 1 {
+			release(true)
+
+			return
+		}
+	}
+}
+
+// releaseStream returns a function that frees the incoming stream with the ID `streamID`
+// and lets the remote know if the consumer stopped receiving values before the stream ended
+func (l *link[T]) releaseStream(streamID string, asCaller bool) func(stopped bool) {
+	write := l.streamWriter(asCaller)
+
+	return func(stopped bool) {
+		l.streams.freeIncoming(streamID)
+
+		if !stopped {
+			return
+		}
+
+		// If this fails, the link is closed and the stream ends anyways
+		_ = write(&utils.Stream[T]{
+			ID:     streamID,
+			Cancel: true,
+		})
+	}
+}
+
+// endStreamArgs ends the streams that the caller passed as the arguments `rawArgs` to a function of type `functionType`
+// without them being used, and returns whether there were any. Streams whose values the caller receives are closed with
+// `err`; for all others, the caller stops sending values.
+func (l *link[T]) endStreamArgs(functionType reflect.Type, rawArgs []T, err error) (bool, error) {
+	ended := false
+	for i := 1; i < len(rawArgs)+1; i++ {
+		if !functionType.IsVariadic() && i >= functionType.NumIn() {
+			break
+		}
+
+		argType := getParamType(functionType, i)
+		if getStreamKind(argType) == streamKindNone && !isSendChan(argType) {
+			continue
+		}
+
+		streamID := ""
+		if err := l.unmarshal(rawArgs[i-1], &streamID); err != nil {
+			return ended, errors.Join(ErrInvalidArg, err)
+		}
+
+		msg := &utils.Stream[T]{
+			ID:     streamID,
+			Cancel: !isSendChan(argType),
+			Close:  isSendChan(argType),
+		}
+
+		if msg.Close {
+			var encodeErr error
+			msg.Err, msg.Code, msg.Details, encodeErr = encodeError(err, l.marshal)
+			if encodeErr != nil {
+				return ended, encodeErr
+			}
+		}
+
+		if err := l.streamWriter(false)(msg); err != nil {
+			return ended, err
+		}
+
+		ended = true
+	}
+
+	return ended, nil
+}
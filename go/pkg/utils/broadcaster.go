@@ -13,6 +13,9 @@ var (
 type channelWithContext[T any] struct {
 	channel chan T
 
+	// Closed instead of `channel` so that concurrent publishers never send on a closed channel
+	done chan struct{}
+
 	ctx    context.Context
 	cancel func(cause error)
 }
@@ -68,6 +71,7 @@ func (b *Broadcaster[T]) Receive(channel string, ctx context.Context) (func() (*
 		ctx, cancel := context.WithCancelCause(ctx)
 		c = channelWithContext[T]{
 			channel: make(chan T),
+			done:    make(chan struct{}),
 
 			ctx:    ctx,
 			cancel: cancel,
@@ -78,12 +82,12 @@ func (b *Broadcaster[T]) Receive(channel string, ctx context.Context) (func() (*
 
 	return func() (*T, error) {
 		select {
-		case v, ok := <-c.channel:
-			if !ok {
-				return nil, ErrClosed
-			}
+		case v := <-c.channel:
 			return &v, nil
 
+		case <-c.done:
+			return nil, ErrClosed
+
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		}
@@ -95,7 +99,7 @@ func (b *Broadcaster[T]) Free(channel string, err error) {
 	c, ok := b.channels[channel]
 	if ok {
 		c.cancel(err)
-		close(c.channel)
+		close(c.done)
 	}
 	delete(b.channels, channel)
 	b.lock.Unlock()
@@ -105,7 +109,7 @@ func (b *Broadcaster[T]) Close(err error) {
 	b.lock.Lock()
 	for _, c := range b.channels {
 		c.cancel(err)
-		close(c.channel)
+		close(c.done)
 	}
 	b.channels = map[string]channelWithContext[T]{}
 	b.closed = true
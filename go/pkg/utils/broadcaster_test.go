@@ -282,3 +282,46 @@ func TestReceiveFromClosedBroadcaster(t *testing.T) {
 	b.lock.Unlock()
 	require.False(t, exists, "Channel should not be created when broadcaster is closed")
 }
+
+func TestPublishConcurrentlyWithFreeAndClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Publishing while the channel is freed or the broadcaster is closed must not send on a closed channel
+	for i := 0; i < 500; i++ {
+		b := NewBroadcaster[string]()
+
+		receive, err := b.Receive("test", ctx)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for k := 0; k < 1000; k++ {
+					b.Publish("test", "hello")
+				}
+			}()
+		}
+
+		_, err = receive()
+		require.NoError(t, err)
+
+		if i%2 == 0 {
+			b.Free("test", nil)
+		} else {
+			b.Close(nil)
+		}
+
+		// Drain the values that were published before the channel was closed
+		for {
+			if _, err := receive(); err != nil {
+				break
+			}
+		}
+
+		wg.Wait()
+	}
+}
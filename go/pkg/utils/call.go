@@ -2,20 +2,43 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
+	"runtime/debug"
 )
 
 var (
 	ErrPanickedWithNonErrorValue = errors.New("panicked with no error value")
 )
 
+// PanicError is returned by `Call` if the function panicked
+type PanicError struct {
+	Value any    // Value the function panicked with
+	Stack []byte // Stack trace of the goroutine at the time of the panic
+}
+
+func (e *PanicError) Error() string {
+	if err, ok := e.Value.(error); ok {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("%v: %v", ErrPanickedWithNonErrorValue, e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+
+	return ErrPanickedWithNonErrorValue
+}
+
 func Call(fn reflect.Value, in []reflect.Value) (out []reflect.Value, err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			var ok bool
-			err, ok = e.(error)
-			if !ok {
-				err = ErrPanickedWithNonErrorValue
+			err = &PanicError{
+				Value: e,
+				Stack: debug.Stack(),
 			}
 		}
 	}()
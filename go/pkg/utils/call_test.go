@@ -2,6 +2,7 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -50,7 +51,7 @@ func TestCall(t *testing.T) {
 			},
 			args:        []interface{}{},
 			expected:    nil,
-			expectedErr: ErrPanickedWithNonErrorValue,
+			expectedErr: fmt.Errorf("%w: %v", ErrPanickedWithNonErrorValue, "string panic"),
 		},
 		{
 			name: "no arguments",
@@ -92,3 +93,24 @@ func TestCall(t *testing.T) {
 		})
 	}
 }
+
+func TestCallPanicError(t *testing.T) {
+	_, err := Call(reflect.ValueOf(func() {
+		panic("string panic")
+	}), []reflect.Value{})
+
+	var panicErr *PanicError
+	require.ErrorAs(t, err, &panicErr)
+	require.Equal(t, "string panic", panicErr.Value)
+	require.Contains(t, string(panicErr.Stack), "TestCallPanicError")
+	require.ErrorIs(t, err, ErrPanickedWithNonErrorValue)
+
+	expectedErr := errors.New("test panic")
+	_, err = Call(reflect.ValueOf(func() {
+		panic(expectedErr)
+	}), []reflect.Value{})
+
+	require.ErrorAs(t, err, &panicErr)
+	require.ErrorIs(t, err, expectedErr)
+	require.NotErrorIs(t, err, ErrPanickedWithNonErrorValue)
+}
@@ -4,6 +4,34 @@ type Request[T any] struct {
 	Call     string `json:"call"`
 	Function string `json:"function"`
 	Args     []T    `json:"args"`
+
+	// Target is the ID of the reference to the object to call the function on; empty if the function is a local RPC.
+	// It can also be the ID of an earlier call with `Pipeline` set, in which case the call is queued until the earlier call has returned.
+	Target string `json:"target,omitempty"`
+
+	// Pipeline signals that the call returns a reference which later calls can target with the call's ID before the call has returned
+	Pipeline bool `json:"pipeline,omitempty"`
+
+	// Notify signals that the caller doesn't wait for the call to return, so no response is sent for it
+	Notify bool `json:"notify,omitempty"`
+
+	// Batch is set if this request is a batch of calls instead of a single call; the calls are answered with a single response
+	Batch []Request[T] `json:"batch,omitempty"`
+
+	// Deadline is the time left until the caller's deadline in milliseconds; zero if the call has no deadline
+	Deadline int64 `json:"deadline,omitempty"`
+
+	// Cancel signals that the caller is no longer interested in the result of the call with the ID `Call`
+	Cancel bool `json:"cancel,omitempty"`
+
+	// Metadata are key-value pairs that are sent along with the call, e.g. a trace ID
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Handshake signals that this request contains the credentials of the caller in `Metadata` instead of a call
+	Handshake bool `json:"handshake,omitempty"`
+
+	// Stream is set if this request is a message of a stream instead of a call
+	Stream *Stream[T] `json:"stream,omitempty"`
 }
 
 func (r *Request[T]) Marshal(marshal func(v any) (T, error)) (T, error) {
@@ -18,6 +46,20 @@ type Response[T any] struct {
 	Call  string `json:"call"`
 	Value T      `json:"value"`
 	Err   string `json:"err"`
+
+	// Code identifies the type of the error in `Err`; empty if the error is not a known one
+	Code string `json:"code,omitempty"`
+	// Details are optional structured details of the error in `Err`
+	Details T `json:"details,omitempty"`
+
+	// Stream is set if this response is a message of a stream instead of the result of a call
+	Stream *Stream[T] `json:"stream,omitempty"`
+
+	// Batch is set if this response contains the results of a batch of calls, in the order of the calls
+	Batch []Response[T] `json:"batch,omitempty"`
+
+	// Metadata are key-value pairs that are sent along with the result of the call
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 func (r *Response[T]) Marshal(marshal func(v any) (T, error)) (T, error) {
@@ -27,3 +69,21 @@ func (r *Response[T]) Marshal(marshal func(v any) (T, error)) (T, error) {
 func (r *Response[T]) Unmarshal(data T, unmarshal func(data T, v any) error) error {
 	return unmarshal(data, r)
 }
+
+// Stream is a message of a stream of values that was passed to or returned by a function
+type Stream[T any] struct {
+	// ID is the ID of the stream; for streams returned by a function, this is the ID of the call
+	ID    string `json:"id"`
+	Value T      `json:"value,omitempty"`
+
+	// Close signals the end of the stream; `Err`, `Code` and `Details` are set if the stream ended with an error
+	Close   bool   `json:"close,omitempty"`
+	Err     string `json:"err,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Details T      `json:"details,omitempty"`
+
+	// Credit is the number of additional values the consumer of the stream is ready to receive
+	Credit int64 `json:"credit,omitempty"`
+	// Cancel signals that the consumer is no longer interested in the values of the stream
+	Cancel bool `json:"cancel,omitempty"`
+}